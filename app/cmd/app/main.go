@@ -5,22 +5,34 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os/signal"
+	"syscall"
 	"time"
+	_ "time/tzdata" // the alpine image has no timezone database
 )
 
+// shutdownTimeout stays below the 10 seconds docker waits before killing the container
+const shutdownTimeout = 8 * time.Second
+
 func main() {
-	a, err := app.NewApp()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	a, err := app.NewApp(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	go a.Run()
-
-	<-a.Signal()
+	runErr := a.Run(ctx)
+	stop() // Run may have given up before a signal, the scheduler stops either way
 	fmt.Println("Shutting down the app...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
-	<-ctx.Done()
+	a.Shutdown(ctx)
+
+	if runErr != nil {
+		log.Fatal(runErr)
+	}
 }
@@ -3,67 +3,227 @@ package app
 import (
 	"app/internal/lib/e"
 	"app/internal/modules/hh"
+	"app/internal/modules/source"
 	"app/internal/modules/tg"
+	"app/internal/scheduler"
 	"app/internal/storage"
+	"context"
+	"errors"
 	"github.com/joho/godotenv"
 	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
-	"os/signal"
-	"syscall"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	minPollBackoff = time.Second
+	maxPollBackoff = time.Minute
+)
+
+// interruptWait is how long Shutdown waits for the searches it has interrupted
+const interruptWait = time.Second
+
+const (
+	modePolling = "polling"
+	modeWebhook = "webhook"
+)
+
+// secret tokens may only contain the characters telegram allows
+var reWebhookSecret = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
 type Config struct {
-	tgHost     string
-	tgApiToken string
-	hhHost     string
+	tgHost      string
+	tgApiToken  string
+	hhHost      string
+	hhPerPage   int
+	hhMaxPages  int
+	interval    time.Duration
+	minInterval time.Duration
+	maxInterval time.Duration
+	searchJobs  int
+	pollTimeout time.Duration
+
+	mode          string
+	webhookUrl    string
+	webhookSecret string
+	webhookAddr   string
 }
 
 type App struct {
-	config     Config
-	signalChan chan os.Signal
-	storage    storage.Storage
-	wAgent     tg.Worker
-	hhClient   hh.HeadHunterer
-	tgClient   tg.Telegramer
+	config    Config
+	storage   storage.Storage
+	wAgent    tg.Worker
+	hhClient  hh.HeadHunterer
+	hhDicts   *hh.DictionaryCache
+	sources   source.Sources
+	searcher  *source.Coordinator
+	scheduler *scheduler.Scheduler
+	tgClient  tg.Telegramer
+
+	stopWork      context.CancelFunc // interrupts the searches and sends that outlived the shutdown timeout
+	schedulerDone chan struct{}
+	server        *http.Server // webhook mode only
 }
 
-func NewApp() (a *App, err error) {
+func NewApp(ctx context.Context) (a *App, err error) {
 	defer func() { err = e.WrapIfErr("failed to init app", err) }()
 
 	a = &App{}
 
-	if err = a.init(); err != nil {
+	if err = a.init(ctx); err != nil {
 		return nil, err
 	}
 
 	return a, nil
 }
 
-func (a *App) Signal() <-chan os.Signal {
-	return a.signalChan
-}
-
-func (a *App) Run() {
+// Run receives the updates until ctx is cancelled, the work started from here outlives ctx,
+// so that it can be finished by Shutdown, the error means the updates can't be received at all
+func (a *App) Run(ctx context.Context) error {
 	log.Println("Firing up the app...")
 
-	for {
-		// get updates every second
-		time.Sleep(1 * time.Second)
+	workCtx, stopWork := context.WithCancel(context.WithoutCancel(ctx))
+	a.stopWork = stopWork
+
+	a.schedulerDone = make(chan struct{})
+	go func() {
+		defer close(a.schedulerDone)
+		a.scheduler.Run(ctx.Done())
+	}()
+
+	go a.tgClient.RunOutbox(workCtx)
+	a.tgClient.ResumeWorkers(workCtx)
 
-		updates, err := a.tgClient.GetUpdates()
+	// the commands still work without the menu
+	if err := a.tgClient.PublishCommands(ctx); err != nil {
+		log.Println(err.Error())
+	}
+
+	if a.config.mode == modeWebhook {
+		return a.serveWebhook(ctx, workCtx)
+	}
+
+	a.poll(ctx, workCtx)
+	return nil
+}
+
+func (a *App) poll(ctx, workCtx context.Context) {
+	// getUpdates doesn't work while a webhook is set
+	if err := a.tgClient.DeleteWebhook(ctx); err != nil {
+		log.Println(err.Error())
+	}
+
+	// getUpdates holds the request until there are updates or the poll timeout expires,
+	// so the loop only has to wait after errors
+	backoff := time.Duration(0)
+	for ctx.Err() == nil {
+		updates, err := a.tgClient.GetUpdates(ctx)
 		if err != nil {
-			log.Println(err.Error()) // skip if an error
+			if ctx.Err() != nil {
+				return
+			}
+
+			backoff = min(max(backoff*2, minPollBackoff), maxPollBackoff)
+			log.Printf("%s, retrying in %v\n", err.Error(), backoff)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
 			continue
 		}
+		backoff = 0
 
 		if len(updates) == 0 { // skip if no updates
 			continue
 		}
 
-		a.tgClient.ProcessUpdates(updates)
+		a.tgClient.ProcessUpdates(workCtx, updates)
+	}
+}
+
+func (a *App) serveWebhook(ctx, workCtx context.Context) error {
+	webhookUrl, err := url.Parse(a.config.webhookUrl)
+	if err != nil {
+		return e.WrapIfErr("invalid webhook url", err)
+	}
+
+	path := webhookUrl.Path
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, a.tgClient.WebhookHandler(workCtx, a.config.webhookSecret))
+
+	// the port is bound before telegram is told about the webhook, so that a busy port stops the app
+	// instead of leaving it deaf
+	listener, err := net.Listen("tcp", a.config.webhookAddr)
+	if err != nil {
+		return e.WrapIfErr("couldn't listen for webhook updates", err)
+	}
+
+	a.server = &http.Server{Handler: mux, ReadHeaderTimeout: time.Second * 10}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("listening for webhook updates on %s%s\n", listener.Addr(), path)
+		if err := a.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- e.WrapIfErr("webhook server failed", err)
+		}
+	}()
+
+	if err = a.tgClient.SetWebhook(ctx, a.config.webhookUrl, a.config.webhookSecret); err != nil {
+		log.Println(err.Error())
 	}
 
+	select {
+	case <-ctx.Done():
+		return nil
+	case err = <-serveErr:
+		return err
+	}
+}
+
+// Shutdown waits for the searches in progress until ctx is done, interrupts the rest and flushes the state of the chats,
+// Run must have returned by then
+func (a *App) Shutdown(ctx context.Context) {
+	if a.server != nil {
+		// the pending updates stay with telegram until the next start
+		if err := a.tgClient.DeleteWebhook(ctx); err != nil {
+			log.Println(err.Error())
+		}
+		if err := a.server.Shutdown(ctx); err != nil {
+			log.Println(e.WrapIfErr("couldn't stop webhook server", err).Error())
+		}
+	}
+
+	select {
+	case <-a.schedulerDone:
+	case <-ctx.Done():
+		log.Println("shutdown timed out, interrupting the searches in progress")
+	}
+
+	a.stopWork()
+
+	// the interrupted searches still save what they haven't delivered
+	select {
+	case <-a.schedulerDone:
+	case <-time.After(interruptWait):
+		log.Println("interrupted searches didn't finish in time")
+	}
+
+	a.tgClient.Flush()
+
+	log.Println("state flushed")
 }
 
 func (a *App) readConfig(envPath ...string) (err error) {
@@ -81,10 +241,56 @@ func (a *App) readConfig(envPath ...string) (err error) {
 	a.config.tgApiToken = os.Getenv("TG_API_TOKEN")
 	a.config.hhHost = os.Getenv("HH_HOST")
 
+	if a.config.hhPerPage, err = getEnvInt("HH_PER_PAGE", 100); err != nil {
+		return err
+	}
+	if a.config.hhMaxPages, err = getEnvInt("HH_MAX_PAGES", 20); err != nil {
+		return err
+	}
+	if a.config.interval, err = getEnvDuration("SEARCH_INTERVAL", time.Minute*10); err != nil {
+		return err
+	}
+	if a.config.minInterval, err = getEnvDuration("MIN_SEARCH_INTERVAL", time.Minute*5); err != nil {
+		return err
+	}
+	if a.config.maxInterval, err = getEnvDuration("MAX_SEARCH_INTERVAL", time.Hour*24); err != nil {
+		return err
+	}
+	if a.config.minInterval > a.config.interval || a.config.interval > a.config.maxInterval {
+		return errors.New("SEARCH_INTERVAL should be between MIN_SEARCH_INTERVAL and MAX_SEARCH_INTERVAL")
+	}
+	if a.config.searchJobs, err = getEnvInt("SEARCH_CONCURRENCY", 4); err != nil {
+		return err
+	}
+	if a.config.pollTimeout, err = getEnvDuration("TG_POLL_TIMEOUT", time.Second*30); err != nil {
+		return err
+	}
+	if a.config.pollTimeout < time.Second {
+		return errors.New("TG_POLL_TIMEOUT should be at least a second")
+	}
+
+	a.config.mode = getEnvString("TG_MODE", modePolling)
+	a.config.webhookUrl = os.Getenv("WEBHOOK_URL")
+	a.config.webhookSecret = os.Getenv("WEBHOOK_SECRET")
+	a.config.webhookAddr = getEnvString("WEBHOOK_ADDR", ":1569")
+
+	switch a.config.mode {
+	case modePolling:
+	case modeWebhook:
+		if !strings.HasPrefix(a.config.webhookUrl, "https://") {
+			return errors.New("WEBHOOK_URL should be an https url in webhook mode")
+		}
+		if !reWebhookSecret.MatchString(a.config.webhookSecret) {
+			return errors.New("WEBHOOK_SECRET should be 1-256 characters of A-Z, a-z, 0-9, _ and - in webhook mode")
+		}
+	default:
+		return errors.New("TG_MODE should be either polling or webhook")
+	}
+
 	return nil
 }
 
-func (a *App) init() error {
+func (a *App) init(ctx context.Context) error {
 
 	a.config = Config{}
 
@@ -92,14 +298,58 @@ func (a *App) init() error {
 		return err
 	}
 
-	a.hhClient = hh.NewHhClient(a.config.hhHost)
+	a.hhClient = hh.NewHhClient(a.config.hhHost, a.config.hhPerPage, a.config.hhMaxPages)
+	a.hhDicts = hh.NewDictionaryCache(a.hhClient, filepath.Join("storage", "hh"), time.Hour*24)
+	if err := a.hhDicts.Load(ctx); err != nil {
+		log.Println(err.Error()) // ids still work without dictionaries
+	}
+
+	a.sources = source.NewSources(source.NewHHSource(a.hhClient), source.NewFeedSource())
+	// queries may run as often as the min interval, shared results shouldn't be older than that
+	a.searcher = source.NewCoordinator(a.sources, a.config.minInterval)
+
+	a.scheduler = scheduler.NewScheduler(scheduler.RealClock{}, rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())), a.config.searchJobs, 0.1)
+
 	a.storage = storage.NewQueriesStorage("storage")
 	a.tgClient = tg.NewTgClient(
-		a.config.tgHost, a.config.tgApiToken, 100, 0, a.hhClient, a.storage, time.Minute*10,
+		a.config.tgHost, a.config.tgApiToken, 100, int(a.config.pollTimeout.Seconds()), a.searcher, a.hhDicts, a.storage, a.scheduler,
+		tg.Intervals{Default: a.config.interval, Min: a.config.minInterval, Max: a.config.maxInterval},
 	)
 
-	a.signalChan = make(chan os.Signal, 1)
-	signal.Notify(a.signalChan, syscall.SIGINT, syscall.SIGTERM)
-
 	return nil
 }
+
+func getEnvString(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, e.WrapIfErr("invalid value of "+key, err)
+	}
+
+	return n, nil
+}
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, e.WrapIfErr("invalid value of "+key, err)
+	}
+
+	return d, nil
+}
@@ -4,69 +4,209 @@ import (
 	"app/internal/lib/e"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"log"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
-	"strings"
+	"path"
+	"strconv"
 	"time"
 )
 
+const (
+	maxPerPage = 100  // hh.ru doesn't accept per_page above this value
+	maxDepth   = 2000 // hh.ru doesn't return more than this many items for a single search
+
+	maxRetries  = 3
+	baseBackoff = 500 * time.Millisecond
+	maxBackoff  = 30 * time.Second
+)
+
 type HeadHunterer interface {
-	GetVacancies(area, role, text, experience string, period int) ([]Vacancy, error)
+	GetVacancies(ctx context.Context, params SearchParams, dateFrom time.Time) ([]Vacancy, int, error)
+	GetVacancy(ctx context.Context, id string) (*VacancyDetails, error)
+	GetAreas(ctx context.Context) ([]AreaNode, error)
+	GetProfessionalRoles(ctx context.Context) (*ProfessionalRolesResponse, error)
+	GetDictionaries(ctx context.Context) (Dictionaries, error)
 }
 
 type Client struct {
-	host   string
-	client *http.Client
+	host       string
+	client     *http.Client
+	perPage    int
+	maxPages   int
+	maxRetries int
+	backoff    time.Duration
 }
 
-func NewHhClient(host string) *Client {
+func NewHhClient(host string, perPage, maxPages int) *Client {
+	if perPage <= 0 || perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	if maxPages <= 0 || maxPages*perPage > maxDepth {
+		maxPages = maxDepth / perPage
+	}
+
 	return &Client{
-		host:   host, // api.hh.ru
-		client: new(http.Client),
+		host:       host, // api.hh.ru
+		client:     new(http.Client),
+		perPage:    perPage,
+		maxPages:   maxPages,
+		maxRetries: maxRetries,
+		backoff:    baseBackoff,
 	}
 }
 
-func (c *Client) GetVacancies(area, role, text, experience string, period int) (vacancies []Vacancy, err error) {
+// GetVacancies walks through the result pages up to the configured limit and returns
+// the aggregated vacancies along with the total number of vacancies found by hh.ru
+func (c *Client) GetVacancies(ctx context.Context, params SearchParams, dateFrom time.Time) (vacancies []Vacancy, found int, err error) {
 	defer func() { err = e.WrapIfErr("couldn't get vacancies", err) }()
 
-	dateFrom := time.Now().AddDate(0, 0, -period).Format("2006-01-02")
+	query := params.Values()
+	query.Set("date_from", dateFrom.Format("2006-01-02T15:04:05-0700"))
+	query.Set("per_page", strconv.Itoa(c.perPage))
 
-	query := url.Values{
-		"area":              []string{area},
-		"text":              []string{text},
-		"professional_role": []string{role},
-		"date_from":         []string{dateFrom},
+	for page := 0; page < c.maxPages; page++ {
+		query.Set("page", strconv.Itoa(page))
+
+		data, err := c.doRequest(ctx, "vacancies", query)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var resp VacanciesResponse
+		if err = json.Unmarshal(data, &resp); err != nil {
+			return nil, 0, err
+		}
+
+		vacancies = append(vacancies, resp.Items...)
+		found = resp.Found
+
+		if resp.Page+1 >= resp.Pages {
+			break
+		}
 	}
 
-	if experience != "" {
-		experience = strings.Replace(experience, "-", " ", -1)
-		query["experience"] = []string{experience}
+	return vacancies, found, nil
+}
+
+func (c *Client) GetVacancy(ctx context.Context, id string) (vacancy *VacancyDetails, err error) {
+	defer func() { err = e.WrapIfErr("couldn't get vacancy "+id, err) }()
+
+	data, err := c.doRequest(ctx, path.Join("vacancies", id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &vacancy); err != nil {
+		return nil, err
 	}
 
-	data, err := c.doRequest("vacancies", query)
+	return vacancy, nil
+}
+
+func (c *Client) GetAreas(ctx context.Context) (areas []AreaNode, err error) {
+	defer func() { err = e.WrapIfErr("couldn't get areas", err) }()
+
+	data, err := c.doRequest(ctx, "areas", nil)
 	if err != nil {
 		return nil, err
 	}
 
-	var resp VacanciesResponse
-	if err = json.Unmarshal(data, &resp); err != nil {
+	if err = json.Unmarshal(data, &areas); err != nil {
 		return nil, err
 	}
 
-	return resp.Items, nil
+	return areas, nil
 }
 
-func (c *Client) doRequest(method string, query url.Values) (data []byte, err error) {
+func (c *Client) GetProfessionalRoles(ctx context.Context) (roles *ProfessionalRolesResponse, err error) {
+	defer func() { err = e.WrapIfErr("couldn't get professional roles", err) }()
+
+	data, err := c.doRequest(ctx, "professional_roles", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &roles); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (c *Client) GetDictionaries(ctx context.Context) (dicts Dictionaries, err error) {
+	defer func() { err = e.WrapIfErr("couldn't get dictionaries", err) }()
+
+	data, err := c.doRequest(ctx, "dictionaries", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	// a few entries are not plain lists of items, those are of no use here
+	dicts = make(Dictionaries, len(raw))
+	for name, value := range raw {
+		var items []DictionaryItem
+		if json.Unmarshal(value, &items) == nil {
+			dicts[name] = items
+		}
+	}
+
+	return dicts, nil
+}
+
+// doRequest performs a GET request, retrying it with exponential backoff
+// on network errors, rate limiting and server errors
+func (c *Client) doRequest(ctx context.Context, method string, query url.Values) (data []byte, err error) {
 	defer func() { err = e.WrapIfErr("couldn't do request", err) }()
 
+	for attempt := 0; ; attempt++ {
+		data, err = c.doAttempt(ctx, method, query)
+		if err == nil || attempt >= c.maxRetries {
+			return data, err
+		}
+
+		delay := c.backoff << attempt
+		var apiErr *APIError
+		switch {
+		case errors.As(err, &apiErr) && !apiErr.temporary():
+			return nil, err
+		case apiErr != nil && apiErr.RetryAfter > maxBackoff:
+			// retrying earlier than asked would only prolong the limit, the next search will try again
+			return nil, err
+		case apiErr != nil && apiErr.RetryAfter > 0:
+			delay = apiErr.RetryAfter
+		default:
+			// add some jitter so that concurrent searches don't retry all at once
+			delay += time.Duration(rand.Int64N(int64(delay)/2 + 1))
+			delay = min(delay, maxBackoff)
+		}
+
+		log.Printf("request to %s failed, retrying in %v: %v\n", method, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *Client) doAttempt(ctx context.Context, method string, query url.Values) (data []byte, err error) {
 	requestUrl := url.URL{
 		Scheme: "https",
 		Host:   c.host,
-		Path:   method, // vacancies or employer or others
+		Path:   method, // vacancies, vacancies/{id} or others
 	}
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, requestUrl.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl.String(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -77,11 +217,16 @@ func (c *Client) doRequest(method string, query url.Values) (data []byte, err er
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	data, err = io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newAPIError(resp, data)
+	}
+
 	return data, nil
 }
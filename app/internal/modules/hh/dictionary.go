@@ -0,0 +1,310 @@
+package hh
+
+import (
+	"app/internal/lib/e"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileAreas        = "areas.json"
+	fileRoles        = "professional_roles.json"
+	fileDictionaries = "dictionaries.json"
+
+	refreshTimeout = time.Minute
+	refreshRetry   = time.Minute * 5 // between the attempts to refresh while hh.ru is unavailable
+)
+
+// Resolver translates human-readable names of reference data into hh.ru ids and back
+type Resolver interface {
+	ResolveArea(nameOrId string) (string, error)
+	ResolveRole(nameOrId string) (string, error)
+	AreaName(id string) string
+	RoleName(id string) string
+	DictionaryName(dictionary, id string) string
+}
+
+type entry struct {
+	id   string
+	name string
+}
+
+type DictionaryCache struct {
+	client HeadHunterer
+	dir    string
+	ttl    time.Duration
+	mux    *sync.RWMutex
+
+	loadedAt    time.Time
+	attemptedAt time.Time // the last load, even a failed one
+	refreshing  bool
+
+	areas     []entry // breadth-first, so countries and regions go before the cities
+	areaNames map[string]string
+	roles     []entry
+	roleNames map[string]string
+	dicts     Dictionaries
+}
+
+func NewDictionaryCache(client HeadHunterer, dir string, ttl time.Duration) *DictionaryCache {
+	return &DictionaryCache{
+		client:    client,
+		dir:       dir, // storage/hh
+		ttl:       ttl,
+		mux:       new(sync.RWMutex),
+		areaNames: make(map[string]string),
+		roleNames: make(map[string]string),
+		dicts:     make(Dictionaries),
+	}
+}
+
+// Load reads reference data from the disk cache, fetching it from hh.ru if the cache is missing or stale
+func (d *DictionaryCache) Load(ctx context.Context) (err error) {
+	defer func() { err = e.WrapIfErr("couldn't load dictionaries", err) }()
+
+	d.mux.Lock()
+	d.attemptedAt = time.Now()
+	d.mux.Unlock()
+
+	var areas []AreaNode
+	if err = d.loadFile(fileAreas, &areas, func() (any, error) { return d.client.GetAreas(ctx) }); err != nil {
+		return err
+	}
+
+	var roles ProfessionalRolesResponse
+	if err = d.loadFile(fileRoles, &roles, func() (any, error) { return d.client.GetProfessionalRoles(ctx) }); err != nil {
+		return err
+	}
+
+	var dicts Dictionaries
+	if err = d.loadFile(fileDictionaries, &dicts, func() (any, error) { return d.client.GetDictionaries(ctx) }); err != nil {
+		return err
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.areas, d.areaNames = indexAreas(areas)
+	d.roles, d.roleNames = indexRoles(roles)
+	d.dicts = dicts
+	d.loadedAt = time.Now()
+
+	log.Printf("loaded %d areas, %d professional roles and %d dictionaries\n", len(d.areas), len(d.roles), len(d.dicts))
+
+	return nil
+}
+
+func (d *DictionaryCache) ResolveArea(nameOrId string) (string, error) {
+	d.refreshIfStale()
+
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	return resolve(d.areas, nameOrId, "area")
+}
+
+func (d *DictionaryCache) ResolveRole(nameOrId string) (string, error) {
+	d.refreshIfStale()
+
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	return resolve(d.roles, nameOrId, "role")
+}
+
+func (d *DictionaryCache) AreaName(id string) string {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	if name, ok := d.areaNames[id]; ok {
+		return name
+	}
+	return id
+}
+
+func (d *DictionaryCache) RoleName(id string) string {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	if name, ok := d.roleNames[id]; ok {
+		return name
+	}
+	return id
+}
+
+func (d *DictionaryCache) DictionaryName(dictionary, id string) string {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	for _, item := range d.dicts[dictionary] {
+		if item.ID == id || item.Code == id {
+			return item.Name
+		}
+	}
+	return id
+}
+
+// refreshIfStale reloads the data in the background, the lookups are made while handling messages,
+// so they go on with the stale data meanwhile, the failed attempts are repeated no sooner than refreshRetry
+func (d *DictionaryCache) refreshIfStale() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	now := time.Now()
+	if d.refreshing || now.Sub(d.loadedAt) <= d.ttl || now.Sub(d.attemptedAt) < refreshRetry {
+		return
+	}
+	d.refreshing = true
+
+	go func() {
+		defer func() {
+			d.mux.Lock()
+			d.refreshing = false
+			d.mux.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+
+		// stale data is still better than nothing, so just log the error
+		if err := d.Load(ctx); err != nil {
+			log.Println(err.Error())
+		}
+	}()
+}
+
+// loadFile decodes the cached file into v, refreshing the file with fetch if it is older than ttl
+func (d *DictionaryCache) loadFile(name string, v any, fetch func() (any, error)) error {
+	path := filepath.Join(d.dir, name)
+
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && time.Since(info.ModTime()) < d.ttl:
+		if err = d.readFile(path, v); err == nil {
+			return nil
+		}
+		log.Println(e.WrapIfErr("couldn't read cached "+name, err).Error())
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	fresh, err := fetch()
+	if err != nil {
+		// fall back to the stale cache if there is one
+		if readErr := d.readFile(path, v); readErr == nil {
+			log.Println(e.WrapIfErr("using stale "+name, err).Error())
+			return nil
+		}
+		return err
+	}
+
+	data, err := json.Marshal(fresh)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(d.dir, 0774); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so that a crash doesn't leave a broken cache behind
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0664); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func (d *DictionaryCache) readFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func indexAreas(tree []AreaNode) ([]entry, map[string]string) {
+	entries := make([]entry, 0)
+	names := make(map[string]string)
+
+	for queue := tree; len(queue) > 0; queue = queue[1:] {
+		node := queue[0]
+		entries = append(entries, entry{id: node.ID, name: node.Name})
+		names[node.ID] = node.Name
+		queue = append(queue, node.Areas...)
+	}
+
+	return entries, names
+}
+
+func indexRoles(resp ProfessionalRolesResponse) ([]entry, map[string]string) {
+	entries := make([]entry, 0)
+	names := make(map[string]string)
+
+	// the same role may be listed in several categories
+	for _, category := range resp.Categories {
+		for _, role := range category.Roles {
+			if _, ok := names[role.ID]; !ok {
+				entries = append(entries, entry{id: role.ID, name: role.Name})
+				names[role.ID] = role.Name
+			}
+		}
+	}
+
+	return entries, names
+}
+
+// resolve looks for an exact match of the name first and falls back to a unique prefix match,
+// underscores in the name stand for spaces since the bot splits messages by spaces
+func resolve(entries []entry, nameOrId, kind string) (string, error) {
+	if _, err := strconv.Atoi(nameOrId); err == nil {
+		return nameOrId, nil
+	}
+
+	name := normalizeName(nameOrId)
+	if name == "" {
+		return "", fmt.Errorf("empty %s name", kind)
+	}
+
+	candidates := make([]entry, 0)
+	for _, en := range entries {
+		normalized := normalizeName(en.name)
+		if normalized == name {
+			return en.id, nil
+		}
+		if strings.HasPrefix(normalized, name) {
+			candidates = append(candidates, en)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("unknown %s %q", kind, nameOrId)
+	case 1:
+		return candidates[0].id, nil
+	}
+
+	names := make([]string, 0, 5)
+	for _, c := range candidates[:min(len(candidates), 5)] {
+		names = append(names, c.name)
+	}
+	return "", fmt.Errorf("ambiguous %s %q, did you mean one of: %s", kind, nameOrId, strings.Join(names, "; "))
+}
+
+func normalizeName(name string) string {
+	name = strings.ToLower(strings.ReplaceAll(name, "_", " "))
+	return strings.TrimSpace(strings.ReplaceAll(name, "ё", "е"))
+}
@@ -31,7 +31,7 @@ type Argument struct {
 
 type Vacancy struct {
 	AcceptIncompleteResumes bool               `json:"accept_incomplete_resumes"`
-	Address                 Address            `json:"address"`
+	Address                 *Address           `json:"address"` // null if not specified
 	AlternateURL            string             `json:"alternate_url"`
 	ApplyAlternateURL       string             `json:"apply_alternate_url"`
 	Area                    Area               `json:"area"`
@@ -48,7 +48,7 @@ type Vacancy struct {
 	Relations               []any              `json:"relations"`
 	ResponseLetterRequired  bool               `json:"response_letter_required"`
 	ResponseURL             any                `json:"response_url"`
-	Salary                  Salary             `json:"salary"`
+	Salary                  *Salary            `json:"salary"` // null if not specified
 	Schedule                Schedule           `json:"schedule"`
 	ShowLogoInSearch        bool               `json:"show_logo_in_search"`
 	Snippet                 Snippet            `json:"snippet"`
@@ -105,12 +105,19 @@ type Department struct {
 }
 
 type Employer struct {
-	AccreditedItEmployer bool   `json:"accredited_it_employer"`
-	AlternateURL         string `json:"alternate_url"`
-	ID                   string `json:"id"`
-	Name                 string `json:"name"`
-	Trusted              bool   `json:"trusted"`
-	URL                  string `json:"url"`
+	AccreditedItEmployer bool     `json:"accredited_it_employer"`
+	AlternateURL         string   `json:"alternate_url"`
+	ID                   string   `json:"id"`
+	LogoURLs             LogoURLs `json:"logo_urls"`
+	Name                 string   `json:"name"`
+	Trusted              bool     `json:"trusted"`
+	URL                  string   `json:"url"`
+}
+
+type LogoURLs struct {
+	Original string `json:"original"`
+	Size90   string `json:"90"`
+	Size240  string `json:"240"`
 }
 
 type ProfessionalRole struct {
@@ -118,17 +125,20 @@ type ProfessionalRole struct {
 	Name string `json:"name"`
 }
 
+// Salary has either of the bounds null if it's open
 type Salary struct {
 	Currency string `json:"currency"`
-	From     int    `json:"from"`
+	From     *int   `json:"from"`
 	Gross    bool   `json:"gross"`
-	To       any    `json:"to"`
+	To       *int   `json:"to"`
 }
 
 type Schedule struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
 }
+
+// Snippet fields may contain <highlighttext> tags around the matched words
 type Snippet struct {
 	Requirement    string `json:"requirement"`
 	Responsibility string `json:"responsibility"`
@@ -138,3 +148,62 @@ type Type struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
 }
+
+// https://api.hh.ru/openapi/redoc#tag/Vakansii/operation/get-vacancy
+
+type VacancyDetails struct {
+	Vacancy
+	Archived     bool           `json:"archived"`
+	Description  string         `json:"description"` // HTML
+	Employment   Employment     `json:"employment"`
+	Experience   Experience     `json:"experience"`
+	KeySkills    []KeySkill     `json:"key_skills"`
+	WorkingHours []WorkingHours `json:"working_hours"`
+}
+
+type Employment struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type Experience struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type KeySkill struct {
+	Name string `json:"name"`
+}
+
+type WorkingHours struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// https://api.hh.ru/openapi/redoc#tag/Obshie-spravochniki
+
+type AreaNode struct {
+	ID       string     `json:"id"`
+	ParentID string     `json:"parent_id"`
+	Name     string     `json:"name"`
+	Areas    []AreaNode `json:"areas"`
+}
+
+type ProfessionalRolesResponse struct {
+	Categories []RoleCategory `json:"categories"`
+}
+
+type RoleCategory struct {
+	ID    string             `json:"id"`
+	Name  string             `json:"name"`
+	Roles []ProfessionalRole `json:"roles"`
+}
+
+// Dictionaries maps a dictionary name (experience, schedule, currency, etc.) to its items
+type Dictionaries map[string][]DictionaryItem
+
+type DictionaryItem struct {
+	ID   string `json:"id"`
+	Code string `json:"code,omitempty"` // currencies are identified by code instead of id
+	Name string `json:"name"`
+}
@@ -0,0 +1,134 @@
+package hh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// https://api.hh.ru/openapi/redoc#section/Obshaya-informaciya/Oshibki-i-kody-otvetov
+
+var (
+	ErrBadArgument     = errors.New("bad argument")
+	ErrCaptchaRequired = errors.New("captcha required")
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotFound        = errors.New("not found")
+	ErrRateLimited     = errors.New("rate limited")
+	ErrServer          = errors.New("server error")
+)
+
+type APIError struct {
+	StatusCode   int           `json:"-"`
+	RetryAfter   time.Duration `json:"-"` // zero if hh.ru didn't send Retry-After
+	RequestID    string        `json:"request_id"`
+	Description  string        `json:"description"`
+	Errors       []ErrorItem   `json:"errors"`
+	BadArguments []BadArgument `json:"bad_arguments"`
+}
+
+type ErrorItem struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	CaptchaURL string `json:"captcha_url,omitempty"`
+}
+
+type BadArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (e *APIError) Error() string {
+	parts := make([]string, 0, len(e.Errors)+len(e.BadArguments))
+	for _, item := range e.Errors {
+		if item.Value != "" && item.Value != item.Type {
+			parts = append(parts, item.Type+" "+item.Value)
+		} else {
+			parts = append(parts, item.Type)
+		}
+	}
+	for _, arg := range e.BadArguments {
+		parts = append(parts, fmt.Sprintf("%s (%s)", arg.Name, arg.Description))
+	}
+	if len(parts) == 0 && e.Description != "" {
+		parts = append(parts, e.Description)
+	}
+
+	msg := fmt.Sprintf("hh api responded with status %d", e.StatusCode)
+	if len(parts) > 0 {
+		msg += ": " + strings.Join(parts, ", ")
+	}
+	if e.RequestID != "" {
+		msg += ", request id " + e.RequestID
+	}
+
+	return msg
+}
+
+// Is allows matching api errors against the Err* values with errors.Is
+func (e *APIError) Is(target error) bool {
+	return e.kind() == target
+}
+
+func (e *APIError) kind() error {
+	for _, item := range e.Errors {
+		switch item.Type {
+		case "captcha_required":
+			return ErrCaptchaRequired
+		case "bad_argument", "bad_arguments":
+			return ErrBadArgument
+		case "not_found":
+			return ErrNotFound
+		}
+	}
+
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusBadRequest:
+		return ErrBadArgument
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode >= http.StatusInternalServerError:
+		return ErrServer
+	}
+
+	return nil
+}
+
+func (e *APIError) temporary() bool {
+	return errors.Is(e, ErrRateLimited) || errors.Is(e, ErrServer)
+}
+
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+
+	// the body is not always json, e.g. when a proxy fails, the status code is enough then
+	_ = json.Unmarshal(body, apiErr)
+
+	apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+
+	return apiErr
+}
+
+// parseRetryAfter accepts both delay-seconds and HTTP-date forms of the header
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+
+	return 0
+}
@@ -0,0 +1,74 @@
+package hh
+
+import (
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SearchParams describes a single vacancy search, see
+// https://api.hh.ru/openapi/redoc#tag/Poisk-vakansij/operation/get-vacancies
+type SearchParams struct {
+	Areas          []string
+	Roles          []string
+	Text           string
+	Experience     string
+	Salary         int
+	OnlyWithSalary bool
+	Currency       string
+	Schedules      []string // e.g. remote
+	Employments    []string
+	SearchFields   []string
+	OrderBy        string
+	Labels         []string
+}
+
+func (p SearchParams) Values() url.Values {
+	query := url.Values{}
+
+	setAll(query, "area", p.Areas)
+	setAll(query, "professional_role", p.Roles)
+	setAll(query, "schedule", p.Schedules)
+	setAll(query, "employment", p.Employments)
+	setAll(query, "search_field", p.SearchFields)
+	setAll(query, "label", p.Labels)
+
+	if p.Text != "" {
+		query.Set("text", p.Text)
+	}
+	if p.Experience != "" {
+		query.Set("experience", p.Experience)
+	}
+	if p.Salary > 0 {
+		query.Set("salary", strconv.Itoa(p.Salary))
+	}
+	if p.OnlyWithSalary {
+		query.Set("only_with_salary", "true")
+	}
+	if p.Currency != "" {
+		query.Set("currency", p.Currency)
+	}
+	if p.OrderBy != "" {
+		query.Set("order_by", p.OrderBy)
+	}
+
+	return query
+}
+
+// Key returns a canonical representation of the params, equal params produce equal keys
+func (p SearchParams) Key() string {
+	return p.Values().Encode()
+}
+
+// setAll adds sorted values so that the same set of values always produces the same key
+func setAll(query url.Values, key string, values []string) {
+	values = slices.Clone(values)
+	slices.Sort(values)
+
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			query.Add(key, v)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package source
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Coordinator sits between the chats and the sources: identical queries from different chats
+// share a single fetch per interval and every chat gets the shared results
+type Coordinator struct {
+	searcher Searcher
+	interval time.Duration
+	mux      *sync.Mutex
+	fetches  map[string]*fetch
+}
+
+type fetch struct {
+	done      chan struct{} // closed once the fields below are set
+	since     time.Time
+	fetchedAt time.Time
+	vacancies []Vacancy
+	found     int
+	err       error
+}
+
+func NewCoordinator(searcher Searcher, interval time.Duration) *Coordinator {
+	return &Coordinator{
+		searcher: searcher,
+		interval: interval,
+		mux:      new(sync.Mutex),
+		fetches:  make(map[string]*fetch),
+	}
+}
+
+func (c *Coordinator) Search(ctx context.Context, q Query, since time.Time) ([]Vacancy, int, error) {
+	key := q.Key()
+
+	c.mux.Lock()
+	c.evictExpired()
+
+	f, ok := c.fetches[key]
+	// a fetch that started later than requested may have missed older vacancies
+	if !ok || f.since.After(since) || f.expired(c.interval) {
+		f = &fetch{done: make(chan struct{}), since: since}
+		c.fetches[key] = f
+		c.mux.Unlock()
+
+		c.run(ctx, key, q, f)
+	} else {
+		c.mux.Unlock()
+		log.Println("sharing search results for", key)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	case <-f.done:
+	}
+
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+
+	vacancies := make([]Vacancy, 0, len(f.vacancies))
+	for _, v := range f.vacancies {
+		// the undated ones are kept like the sources keep them, the seen ones are skipped later
+		if v.PublishedAt.IsZero() || !v.PublishedAt.Before(since) {
+			vacancies = append(vacancies, v)
+		}
+	}
+
+	// the vacancies filtered out were found, but they aren't in the results of this chat,
+	// only the ones the source couldn't fetch still count as missing
+	return vacancies, len(vacancies) + max(f.found-len(f.vacancies), 0), nil
+}
+
+// run fetches with the context of the chat that came first, if that one gets cancelled,
+// the others get the error and retry on their next search
+func (c *Coordinator) run(ctx context.Context, key string, q Query, f *fetch) {
+	defer close(f.done)
+
+	f.vacancies, f.found, f.err = c.searcher.Search(ctx, q, f.since)
+	f.fetchedAt = time.Now()
+
+	if f.err != nil {
+		// don't keep errors around, the next search should try again
+		c.mux.Lock()
+		if c.fetches[key] == f {
+			delete(c.fetches, key)
+		}
+		c.mux.Unlock()
+	}
+}
+
+// evictExpired drops finished fetches nobody is going to reuse, the caller must hold the lock
+func (c *Coordinator) evictExpired() {
+	for key, f := range c.fetches {
+		if f.expired(c.interval) {
+			delete(c.fetches, key)
+		}
+	}
+}
+
+// expired reports whether the fetch has finished more than interval ago, in-flight fetches never expire
+func (f *fetch) expired(interval time.Duration) bool {
+	select {
+	case <-f.done:
+		return time.Since(f.fetchedAt) >= interval
+	default:
+		return false
+	}
+}
@@ -0,0 +1,107 @@
+package source
+
+import (
+	"app/internal/modules/hh"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingSearcher returns the same vacancies for any query and counts the fetches
+type countingSearcher struct {
+	vacancies []Vacancy
+	found     int
+	fetches   atomic.Int32
+}
+
+func (s *countingSearcher) Search(_ context.Context, _ Query, since time.Time) ([]Vacancy, int, error) {
+	s.fetches.Add(1)
+
+	vacancies := make([]Vacancy, 0, len(s.vacancies))
+	for _, v := range s.vacancies {
+		if v.PublishedAt.IsZero() || !v.PublishedAt.Before(since) {
+			vacancies = append(vacancies, v)
+		}
+	}
+	return vacancies, s.found - (len(s.vacancies) - len(vacancies)), nil
+}
+
+func TestCoordinatorSharesFetch(t *testing.T) {
+	now := time.Now()
+	searcher := &countingSearcher{
+		vacancies: []Vacancy{
+			{ID: "old", Source: SourceFeed, PublishedAt: now.Add(-time.Hour * 2)},
+			{ID: "new", Source: SourceFeed, PublishedAt: now.Add(-time.Minute)},
+			{ID: "undated", Source: SourceFeed},
+		},
+		found: 3,
+	}
+	c := NewCoordinator(searcher, time.Minute)
+	q := Query{Source: SourceFeed, FeedURL: "https://example.com/jobs.rss", SearchParams: hh.SearchParams{Text: "golang"}}
+
+	vacancies, found, err := c.Search(context.Background(), q, now.Add(-time.Hour*3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vacancies) != 3 || found != 3 {
+		t.Fatalf("first chat got %d vacancies of %d found, want 3 of 3", len(vacancies), found)
+	}
+
+	// the second chat has seen the old one already, its results come from the same fetch
+	vacancies, found, err = c.Search(context.Background(), q, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := searcher.fetches.Load(); n != 1 {
+		t.Fatalf("%d fetches made, want 1 shared", n)
+	}
+	if len(vacancies) != 2 {
+		t.Errorf("second chat got %d vacancies, want the new and the undated ones", len(vacancies))
+	}
+	if found != len(vacancies) {
+		t.Errorf("second chat got found %d for %d vacancies, the results would look truncated", found, len(vacancies))
+	}
+}
+
+func TestCoordinatorKeepsTruncation(t *testing.T) {
+	now := time.Now()
+	searcher := &countingSearcher{
+		vacancies: []Vacancy{
+			{ID: "1", Source: SourceHH, PublishedAt: now.Add(-time.Hour * 2)},
+			{ID: "2", Source: SourceHH, PublishedAt: now.Add(-time.Minute)},
+		},
+		found: 10, // the source couldn't fetch them all
+	}
+	c := NewCoordinator(searcher, time.Minute)
+	q := Query{SearchParams: hh.SearchParams{Text: "golang"}}
+
+	if _, _, err := c.Search(context.Background(), q, now.Add(-time.Hour*3)); err != nil {
+		t.Fatal(err)
+	}
+
+	vacancies, found, err := c.Search(context.Background(), q, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vacancies) != 1 || found != 9 {
+		t.Errorf("shared fetch gave %d vacancies of %d found, want 1 of 9", len(vacancies), found)
+	}
+}
+
+func TestCoordinatorRefetchesForEarlierSince(t *testing.T) {
+	now := time.Now()
+	searcher := &countingSearcher{vacancies: []Vacancy{{ID: "1", Source: SourceHH, PublishedAt: now}}, found: 1}
+	c := NewCoordinator(searcher, time.Minute)
+	q := Query{SearchParams: hh.SearchParams{Text: "golang"}}
+
+	for _, since := range []time.Time{now.Add(-time.Hour), now.Add(-time.Hour * 2)} {
+		if _, _, err := c.Search(context.Background(), q, since); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := searcher.fetches.Load(); n != 2 {
+		t.Errorf("%d fetches made, want 2, the first one may have missed older vacancies", n)
+	}
+}
@@ -0,0 +1,265 @@
+package source
+
+import (
+	"app/internal/lib/e"
+	"context"
+	"crypto/sha1"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	maxFeedSize      = 10 << 20
+	maxFeedRedirects = 5
+)
+
+var errForbiddenAddress = errors.New("feed address isn't public")
+
+// FeedSource reads vacancies from RSS 2.0, RSS 1.0 and Atom job feeds,
+// the query text is used as a list of keywords that all have to be present in a vacancy
+type FeedSource struct {
+	client *http.Client
+}
+
+// NewFeedSource only lets the client reach public addresses, the feeds are submitted by the users
+// and must not make the server request its own or internal services
+func NewFeedSource() *FeedSource {
+	dialer := &net.Dialer{Timeout: time.Second * 10, Control: controlPublic}
+
+	return &FeedSource{client: &http.Client{
+		Timeout: time.Second * 30,
+		// no proxy, the dialer has to see the address of the feed itself
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: time.Second * 10,
+		},
+		CheckRedirect: checkRedirect,
+	}}
+}
+
+func (s *FeedSource) Name() string {
+	return SourceFeed
+}
+
+func (s *FeedSource) Search(ctx context.Context, q Query, since time.Time) (vacancies []Vacancy, found int, err error) {
+	defer func() { err = e.WrapIfErr("couldn't read feed "+q.FeedURL, err) }()
+
+	data, err := s.doRequest(ctx, q.FeedURL)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var f feed
+	if err = xml.Unmarshal(data, &f); err != nil {
+		return nil, 0, err
+	}
+
+	keywords := strings.Fields(strings.ToLower(strings.ReplaceAll(q.Text, "-", " ")))
+
+	for _, v := range f.vacancies() {
+		if !v.PublishedAt.IsZero() && v.PublishedAt.Before(since) {
+			continue
+		}
+		if !matchesAll(strings.ToLower(v.Title+" "+v.Employer+" "+v.description), keywords) {
+			continue
+		}
+		vacancies = append(vacancies, v.Vacancy)
+	}
+
+	return vacancies, len(vacancies), nil
+}
+
+func (s *FeedSource) doRequest(ctx context.Context, feedUrl string) (data []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("feed responded with status %d", resp.StatusCode)
+	}
+
+	// the feeds are submitted by the users, so a huge one shouldn't be read into memory
+	data, err = io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFeedSize {
+		return nil, fmt.Errorf("feed is larger than %d bytes", maxFeedSize)
+	}
+
+	return data, nil
+}
+
+// controlPublic runs for every resolved address the client connects to,
+// so the host names resolving to internal addresses are caught as well
+func controlPublic(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublic(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", errForbiddenAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// checkRedirect rejects the redirects to internal hosts before they are requested,
+// the ones hidden behind host names are still stopped by the dialer
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxFeedRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxFeedRedirects)
+	}
+
+	return CheckFeedHost(req.URL.Hostname())
+}
+
+// CheckFeedHost rejects the hosts that are internal by their name or address alone,
+// so that such feeds are refused when they are added
+func CheckFeedHost(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", errForbiddenAddress, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublic(addr) {
+		return fmt.Errorf("%w: %s", errForbiddenAddress, host)
+	}
+	return nil
+}
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !addr.IsLoopback() && !addr.IsLinkLocalUnicast()
+}
+
+func matchesAll(text string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if !strings.Contains(text, keyword) {
+			return false
+		}
+	}
+	return true
+}
+
+// feed covers all the supported formats at once, only the fields of one of them are filled
+type feed struct {
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"` // RSS 1.0 keeps items outside the channel
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Author      string `xml:"author"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Description string `xml:"description"`
+}
+
+type atomEntry struct {
+	Title string `xml:"title"`
+	ID    string `xml:"id"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	Author    struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Summary string `xml:"summary"`
+	Content string `xml:"content"`
+}
+
+type feedVacancy struct {
+	Vacancy
+	description string
+}
+
+func (f *feed) vacancies() []feedVacancy {
+	vacancies := make([]feedVacancy, 0)
+
+	for _, item := range append(f.Channel.Items, f.Items...) {
+		vacancies = append(vacancies, feedVacancy{
+			Vacancy: Vacancy{
+				ID:          feedId(firstNonEmpty(item.GUID, item.Link, item.Title)),
+				Source:      SourceFeed,
+				Title:       strings.TrimSpace(item.Title),
+				Employer:    strings.TrimSpace(firstNonEmpty(item.Creator, item.Author)),
+				URL:         strings.TrimSpace(item.Link),
+				PublishedAt: parseFeedTime(firstNonEmpty(item.PubDate, item.Date)),
+			},
+			description: item.Description,
+		})
+	}
+
+	for _, entry := range f.Entries {
+		link := ""
+		for _, l := range entry.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				link = l.Href
+				break
+			}
+		}
+
+		vacancies = append(vacancies, feedVacancy{
+			Vacancy: Vacancy{
+				ID:          feedId(firstNonEmpty(entry.ID, link, entry.Title)),
+				Source:      SourceFeed,
+				Title:       strings.TrimSpace(entry.Title),
+				Employer:    strings.TrimSpace(entry.Author.Name),
+				URL:         strings.TrimSpace(link),
+				PublishedAt: parseFeedTime(firstNonEmpty(entry.Published, entry.Updated)),
+			},
+			description: entry.Summary + " " + entry.Content,
+		})
+	}
+
+	return vacancies
+}
+
+// feedId shortens guids, which are often full urls, to keep vacancy keys short
+func feedId(guid string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.TrimSpace(guid))))[:16]
+}
+
+func parseFeedTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	layouts := []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST"}
+
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
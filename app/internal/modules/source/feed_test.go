@@ -0,0 +1,69 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestFeedRejectsInternalAddresses(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	_, _, err := NewFeedSource().Search(context.Background(), Query{Source: SourceFeed, FeedURL: server.URL}, time.Time{})
+	if !errors.Is(err, errForbiddenAddress) {
+		t.Errorf("loopback feed gave %v, want %v", err, errForbiddenAddress)
+	}
+	if requested {
+		t.Error("loopback feed was requested")
+	}
+}
+
+func TestFeedRejectsRedirectsToInternalAddresses(t *testing.T) {
+	for _, target := range []string{
+		"http://127.0.0.1/feed",
+		"http://localhost:8080/feed",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/feed",
+		"http://[::1]/feed",
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if err := checkRedirect(req, []*http.Request{{}}); !errors.Is(err, errForbiddenAddress) {
+			t.Errorf("redirect to %s gave %v, want %v", target, err, errForbiddenAddress)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/feed", nil)
+	if err := checkRedirect(req, []*http.Request{{}}); err != nil {
+		t.Errorf("redirect to a public host gave %v", err)
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::":    true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"0.0.0.0":              false,
+		"::1":                  false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:93.184.216.34": true,
+	} {
+		if got := isPublic(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("isPublic(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package source
+
+import (
+	"app/internal/modules/hh"
+	"context"
+	"time"
+)
+
+const hhTimeLayout = "2006-01-02T15:04:05-0700"
+
+type HHSource struct {
+	client hh.HeadHunterer
+}
+
+func NewHHSource(client hh.HeadHunterer) *HHSource {
+	return &HHSource{client: client}
+}
+
+func (s *HHSource) Name() string {
+	return SourceHH
+}
+
+func (s *HHSource) Search(ctx context.Context, q Query, since time.Time) ([]Vacancy, int, error) {
+	items, found, err := s.client.GetVacancies(ctx, q.SearchParams, since)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	vacancies := make([]Vacancy, 0, len(items))
+	for i := range items {
+		vacancies = append(vacancies, fromHH(&items[i]))
+	}
+
+	return vacancies, found, nil
+}
+
+func fromHH(v *hh.Vacancy) Vacancy {
+	vacancy := Vacancy{
+		ID:         v.ID,
+		Source:     SourceHH,
+		Title:      v.Name,
+		Employer:   v.Employer.Name,
+		EmployerID: v.Employer.ID,
+		URL:        v.AlternateURL,
+		HH:         v,
+	}
+
+	if vacancy.URL == "" {
+		vacancy.URL = "https://hh.ru/vacancy/" + v.ID
+	}
+
+	if publishedAt, err := time.Parse(hhTimeLayout, v.PublishedAt); err == nil {
+		vacancy.PublishedAt = publishedAt
+	}
+
+	return vacancy
+}
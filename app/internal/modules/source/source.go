@@ -0,0 +1,99 @@
+package source
+
+import (
+	"app/internal/modules/hh"
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	SourceHH   = "hh"
+	SourceFeed = "feed"
+)
+
+type Searcher interface {
+	Search(ctx context.Context, q Query, since time.Time) (vacancies []Vacancy, found int, err error)
+}
+
+// VacancySource is a job board adapter, it returns vacancies published after since
+// along with the total number of vacancies matching the query
+type VacancySource interface {
+	Searcher
+	Name() string
+}
+
+// Query is a source-neutral search query, hh.SearchParams are used by the feed sources
+// as a keyword filter
+type Query struct {
+	Source  string // hh if empty
+	FeedURL string
+	hh.SearchParams
+}
+
+// Key returns a canonical representation of the query, hh keys stay the same as hh.SearchParams keys
+func (q Query) Key() string {
+	if q.SourceName() == SourceHH {
+		return q.SearchParams.Key()
+	}
+	return q.Source + ":" + q.FeedURL + "?" + q.SearchParams.Key()
+}
+
+func (q Query) SourceName() string {
+	if q.Source == "" {
+		return SourceHH
+	}
+	return q.Source
+}
+
+// Host returns the host of the feed for displaying purposes
+func (q Query) Host() string {
+	if u, err := url.Parse(q.FeedURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return q.FeedURL
+}
+
+// Vacancy is a normalized vacancy from any of the sources
+type Vacancy struct {
+	ID          string // unique within the source
+	Source      string
+	Title       string
+	Employer    string
+	EmployerID  string
+	URL         string
+	PublishedAt time.Time
+	HH          *hh.Vacancy // the original vacancy for the ones from hh.ru
+}
+
+func (v Vacancy) Key() string {
+	return v.Source + ":" + v.ID
+}
+
+// EmployerKey returns an empty string if the source doesn't identify employers
+func (v Vacancy) EmployerKey() string {
+	if v.EmployerID == "" {
+		return ""
+	}
+	return v.Source + ":" + v.EmployerID
+}
+
+// Sources dispatches queries to the source they belong to
+type Sources map[string]VacancySource
+
+func NewSources(sources ...VacancySource) Sources {
+	s := make(Sources, len(sources))
+	for _, source := range sources {
+		s[source.Name()] = source
+	}
+	return s
+}
+
+func (s Sources) Search(ctx context.Context, q Query, since time.Time) ([]Vacancy, int, error) {
+	source, ok := s[q.SourceName()]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown source %s", q.SourceName())
+	}
+	return source.Search(ctx, q, since)
+}
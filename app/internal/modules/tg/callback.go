@@ -0,0 +1,73 @@
+package tg
+
+import (
+	"app/internal/lib/e"
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"strconv"
+)
+
+// processCallback records the decision made with a notification button and marks the button as chosen
+func (c *Client) processCallback(ctx context.Context, callback *CallbackQuery) {
+	log.Printf("got callback %s%s%s from %s%d%s", Magenta, callback.Data, Reset, Green, callback.From.Id, Reset)
+
+	// telegram doesn't send the message if it is too old
+	if callback.Message == nil {
+		c.answerCallback(ctx, callback.ID, "The message is too old")
+		return
+	}
+
+	action, key, err := parseCallbackData(callback.Data)
+	if err != nil {
+		log.Println(err.Error())
+		c.answerCallback(ctx, callback.ID, "Unknown button")
+		return
+	}
+
+	worker := c.handleWorker(callback.Message.Chat.ID)
+	if err = worker.Decide(action, key); err != nil {
+		log.Println(err.Error())
+		c.answerCallback(ctx, callback.ID, "Couldn't save the decision, try again")
+		return
+	}
+
+	c.answerCallback(ctx, callback.ID, actionNames[action]+" 👌🏻")
+
+	if callback.Message.ReplyMarkup != nil {
+		keyboard := markDecision(*callback.Message.ReplyMarkup, callback.Data)
+		if err = c.editReplyMarkup(ctx, callback.Message.Chat.ID, callback.Message.MessageID, keyboard); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// answerCallback stops the loading animation of the button, showing text to the user
+func (c *Client) answerCallback(ctx context.Context, callbackId, text string) {
+	query := url.Values{
+		"callback_query_id": []string{callbackId},
+		"text":              []string{text},
+	}
+
+	if err := c.call(ctx, methodAnswerCallbackQuery, query); err != nil {
+		log.Println(e.WrapIfErr("couldn't answer callback", err).Error())
+	}
+}
+
+func (c *Client) editReplyMarkup(ctx context.Context, chatId, messageId int, keyboard InlineKeyboardMarkup) (err error) {
+	defer func() { err = e.WrapIfErr("couldn't edit reply markup", err) }()
+
+	markup, err := json.Marshal(keyboard)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{
+		"chat_id":      []string{strconv.Itoa(chatId)},
+		"message_id":   []string{strconv.Itoa(messageId)},
+		"reply_markup": []string{string(markup)},
+	}
+
+	return c.call(ctx, methodEditMessageReplyMarkup, query)
+}
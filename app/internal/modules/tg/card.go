@@ -0,0 +1,193 @@
+package tg
+
+import (
+	"app/internal/modules/hh"
+	"app/internal/modules/source"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxSnippetLength = 300 // runes, the snippets are short anyway, but they come from the employers
+
+var currencySymbols = map[string]string{
+	"RUR": "₽",
+	"RUB": "₽",
+	"USD": "$",
+	"EUR": "€",
+	"KZT": "₸",
+	"UAH": "₴",
+	"BYR": "Br",
+}
+
+var highlightStripper = strings.NewReplacer("<highlighttext>", "", "</highlighttext>", "")
+
+// vacancyMessage renders the notification card, all the text coming from the users and the sources is escaped,
+// since the messages are sent with parse_mode=HTML
+func vacancyMessage(v source.Vacancy, queries []numberedQuery, loc *time.Location) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "<b><a href=\"%s\">%s</a></b>\n", html.EscapeString(v.URL), html.EscapeString(v.Title))
+
+	if v.HH != nil {
+		writeHHDetails(&b, v.HH)
+	} else if v.Employer != "" {
+		fmt.Fprintf(&b, "🏢 %s\n", html.EscapeString(v.Employer))
+	}
+
+	if !v.PublishedAt.IsZero() {
+		fmt.Fprintf(&b, "🕒 Published %s\n", v.PublishedAt.In(loc).Format("2 Jan 15:04"))
+	}
+
+	fmt.Fprintf(&b, "\nFound by %s", matchedQueries(queries))
+
+	return b.String()
+}
+
+func writeHHDetails(b *strings.Builder, v *hh.Vacancy) {
+	if v.Employer.Name != "" {
+		fmt.Fprintf(b, "🏢 %s%s\n", html.EscapeString(v.Employer.Name), employerMarkers(v.Employer))
+	}
+
+	if v.Salary != nil {
+		if salary := formatSalary(*v.Salary); salary != "" {
+			fmt.Fprintf(b, "💰 %s\n", salary)
+		}
+	}
+
+	if place := formatPlace(v); place != "" {
+		fmt.Fprintf(b, "📍 %s\n", place)
+	}
+
+	if v.Schedule.Name != "" {
+		fmt.Fprintf(b, "🗓 %s\n", html.EscapeString(v.Schedule.Name))
+	}
+
+	if requirement := cleanSnippet(v.Snippet.Requirement); requirement != "" {
+		fmt.Fprintf(b, "<i>Requirements:</i> %s\n", requirement)
+	}
+	if responsibility := cleanSnippet(v.Snippet.Responsibility); responsibility != "" {
+		fmt.Fprintf(b, "<i>Responsibilities:</i> %s\n", responsibility)
+	}
+}
+
+func employerMarkers(employer hh.Employer) string {
+	markers := ""
+	if employer.Trusted {
+		markers += " ✔️ verified"
+	}
+	if employer.AccreditedItEmployer {
+		markers += " 🏅 IT accredited"
+	}
+	return markers
+}
+
+// formatSalary returns an empty string if both of the bounds are open
+func formatSalary(s hh.Salary) string {
+	var amount string
+	switch {
+	case s.From != nil && s.To != nil && *s.From == *s.To:
+		amount = formatAmount(*s.From)
+	case s.From != nil && s.To != nil:
+		amount = formatAmount(*s.From) + "–" + formatAmount(*s.To)
+	case s.From != nil:
+		amount = "from " + formatAmount(*s.From)
+	case s.To != nil:
+		amount = "up to " + formatAmount(*s.To)
+	default:
+		return ""
+	}
+
+	currency, ok := currencySymbols[s.Currency]
+	if !ok {
+		currency = html.EscapeString(s.Currency)
+	}
+
+	tax := "after tax"
+	if s.Gross {
+		tax = "before tax"
+	}
+
+	return fmt.Sprintf("%s %s, %s", amount, currency, tax)
+}
+
+// formatAmount groups the thousands with non-breaking spaces, e.g. 150 000
+func formatAmount(n int) string {
+	digits := strconv.Itoa(n)
+	if n < 0 {
+		return digits
+	}
+
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteRune('\u00a0')
+		}
+		b.WriteRune(d)
+	}
+	return b.String()
+}
+
+func formatPlace(v *hh.Vacancy) string {
+	place := html.EscapeString(v.Area.Name)
+	if v.Address == nil {
+		return place
+	}
+
+	stations := make([]string, 0, len(v.Address.MetroStations))
+	listed := make(map[string]bool)
+	for _, station := range v.Address.MetroStations {
+		if station.StationName == "" || listed[station.StationName] {
+			continue
+		}
+		listed[station.StationName] = true
+		stations = append(stations, html.EscapeString(station.StationName))
+	}
+
+	if len(stations) == 0 {
+		return place
+	}
+	if place == "" {
+		return "🚇 " + strings.Join(stations, ", ")
+	}
+	return place + ", 🚇 " + strings.Join(stations, ", ")
+}
+
+// cleanSnippet drops the highlighting of hh.ru and escapes the rest, the entities hh.ru escapes itself
+// are unescaped first, so that they aren't escaped twice
+func cleanSnippet(snippet string) string {
+	text := strings.TrimSpace(html.UnescapeString(highlightStripper.Replace(snippet)))
+
+	if runes := []rune(text); len(runes) > maxSnippetLength {
+		text = strings.TrimSpace(string(runes[:maxSnippetLength])) + "…"
+	}
+
+	return html.EscapeString(text)
+}
+
+func matchedQueries(queries []numberedQuery) string {
+	matched := make([]string, len(queries))
+	for i, q := range queries {
+		switch {
+		case q.SourceName() == source.SourceFeed:
+			matched[i] = fmt.Sprintf("%d – <i>%s</i>", q.number, html.EscapeString(q.Host()))
+		case q.Experience != "":
+			matched[i] = fmt.Sprintf("%d – <i>%s</i> (%s)", q.number, html.EscapeString(q.Text), html.EscapeString(experienceName(q.Experience)))
+		default:
+			matched[i] = fmt.Sprintf("%d – <i>%s</i>", q.number, html.EscapeString(q.Text))
+		}
+	}
+	return strings.Join(matched, ", ")
+}
+
+// experienceName returns the id itself if it's unknown
+func experienceName(id string) string {
+	for _, choice := range experienceChoices {
+		if choice.id == id {
+			return choice.text
+		}
+	}
+	return id
+}
@@ -3,10 +3,13 @@ package tg
 import (
 	"app/internal/lib/e"
 	"app/internal/modules/hh"
+	"app/internal/modules/source"
+	"app/internal/scheduler"
 	"app/internal/storage"
 	"context"
 	"encoding/json"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"net/http"
@@ -15,6 +18,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,55 +30,103 @@ const (
 )
 
 const (
-	methodGetUpdates  = "getUpdates"  // Use this method to receive incoming updates using long polling. Returns an Array of Update objects
-	methodSendMessage = "SendMessage" // Use this method to send text messages. On success, the sent Message is returned
+	methodGetUpdates    = "getUpdates"    // Use this method to receive incoming updates using long polling. Returns an Array of Update objects
+	methodSendMessage   = "SendMessage"   // Use this method to send text messages. On success, the sent Message is returned
+	methodSetWebhook    = "setWebhook"    // Use this method to specify a URL and receive incoming updates via an outgoing webhook
+	methodDeleteWebhook = "deleteWebhook" // Use this method to remove webhook integration if you decide to switch back to getUpdates
+
+	methodGetMe         = "getMe"         // A simple method for testing your bot's authentication token. Returns basic information about the bot
+	methodSetMyCommands = "setMyCommands" // Use this method to change the list of the bot's commands
+
+	methodAnswerCallbackQuery    = "answerCallbackQuery"    // Use this method to send answers to callback queries sent from inline keyboards
+	methodEditMessageReplyMarkup = "editMessageReplyMarkup" // Use this method to edit only the reply markup of messages
 )
 
+// requestMargin is added to the long polling timeout, so that the http client doesn't give up
+// before telegram answers an empty getUpdates
+const requestMargin = time.Second * 10
+
 type Telegramer interface {
-	GetUpdates() ([]Update, error)
-	ProcessUpdates(updates []Update)
-	SendMessage(chatId int, text string)
+	GetUpdates(ctx context.Context) ([]Update, error)
+	ProcessUpdates(ctx context.Context, updates []Update)
+	ResumeWorkers(ctx context.Context)
+	RunOutbox(ctx context.Context)
+	SendMessage(ctx context.Context, chatId int, text string) error
+	SendMessageWithKeyboard(ctx context.Context, chatId int, text string, keyboard InlineKeyboardMarkup) error
+	PublishCommands(ctx context.Context) error
+	SetWebhook(ctx context.Context, webhookUrl, secret string) error
+	DeleteWebhook(ctx context.Context) error
+	WebhookHandler(ctx context.Context, secret string) http.Handler
+	Flush()
 }
 
 type Client struct {
 	host     string
 	basePath string
 	tgClient *http.Client
-	offset   int
+	offset   int // of the next getUpdates, it is confirmed by the request
 	limit    int
-	timeout  int
+	timeout  int // long polling timeout in seconds
+
+	outbox *outbox
+
+	offsetMux *sync.Mutex
+	confirmed int   // the offset telegram has acknowledged
+	processed []int // the updates handled since then
 
-	hhClient hh.HeadHunterer
+	searcher source.Searcher
+	resolver hh.Resolver
 
-	workers  map[int]Worker
-	interval time.Duration
-	storage  storage.Storage
+	workersMux *sync.Mutex // webhook updates are handled concurrently
+	workers    map[int]Worker
+	dialogsMux *sync.Mutex
+	dialogs    map[int]*dialog // of the chats going through the /add wizard
+	intervals  Intervals
+	storage    storage.Storage
+	scheduler  *scheduler.Scheduler
+
+	router   *router
+	username string // of the bot, known once the commands are published
 
 	reAdd    *regexp.Regexp
+	reFeed   *regexp.Regexp
 	reRemove *regexp.Regexp
 }
 
-func NewTgClient(host string, token string, batchSize, timeout int, hhClient hh.HeadHunterer, storage storage.Storage, workingInterval time.Duration) *Client {
-	return &Client{
+func NewTgClient(host string, token string, batchSize, timeout int, searcher source.Searcher, resolver hh.Resolver, storage storage.Storage, sched *scheduler.Scheduler, intervals Intervals) *Client {
+	c := &Client{
 		host:     host,          // api.tg.org
 		basePath: "bot" + token, // app<token>
-		tgClient: new(http.Client),
+		tgClient: &http.Client{Timeout: time.Duration(timeout)*time.Second + requestMargin},
 		offset:   0,
 		limit:    batchSize,
 		timeout:  timeout,
 
-		hhClient: hhClient,
+		offsetMux: new(sync.Mutex),
+
+		searcher: searcher,
+		resolver: resolver,
 
-		workers:  make(map[int]Worker),
-		interval: workingInterval,
-		storage:  storage,
+		workersMux: new(sync.Mutex),
+		workers:    make(map[int]Worker),
+		dialogsMux: new(sync.Mutex),
+		dialogs:    make(map[int]*dialog),
+		intervals:  intervals,
+		storage:    storage,
+		scheduler:  sched,
 
-		reAdd:    regexp.MustCompile(`add: \d+ \d+ [a-zA-Zа-яА-Я-]+ (-|0|1-3|3-6|6)`),
+		reAdd:    regexp.MustCompile(`add: \S+ \S+ [a-zA-Zа-яА-Я-]+ (-|0|1-3|3-6|6)( [a-z_]+=\S+)*`),
+		reFeed:   regexp.MustCompile(`feed: https?://\S+( .+)?`),
 		reRemove: regexp.MustCompile(`remove: \d+`),
+		router:   newRouter(),
 	}
+	c.outbox = newOutbox(storage, c.deliver)
+	c.registerCommands()
+	c.restoreOffset()
+	return c
 }
 
-func (c *Client) GetUpdates() (updates []Update, err error) {
+func (c *Client) GetUpdates(ctx context.Context) (updates []Update, err error) {
 	defer func() { err = e.WrapIfErr("couldn't get updates", err) }()
 
 	query := url.Values{
@@ -83,7 +135,7 @@ func (c *Client) GetUpdates() (updates []Update, err error) {
 		"timeout": []string{strconv.Itoa(c.timeout)},
 	}
 
-	data, err := c.doRequest(methodGetUpdates, query)
+	data, err := c.doRequest(ctx, methodGetUpdates, query)
 	if err != nil {
 		return nil, err
 	}
@@ -97,6 +149,8 @@ func (c *Client) GetUpdates() (updates []Update, err error) {
 		return nil, fmt.Errorf(res.Description)
 	}
 
+	c.confirmOffset(c.offset)
+
 	if updates = res.Result; len(updates) == 0 {
 		return updates, nil
 	}
@@ -106,126 +160,203 @@ func (c *Client) GetUpdates() (updates []Update, err error) {
 	return updates, nil
 }
 
-func (c *Client) ProcessUpdates(updates []Update) {
+// ProcessUpdates handles the messages with ctx, the workers started by them keep running until ctx is cancelled
+func (c *Client) ProcessUpdates(ctx context.Context, updates []Update) {
 	for _, update := range updates {
 
-		// ignore everything that is not a message
-		if update.Message == nil {
+		// telegram delivers the unconfirmed updates again after a restart
+		if !c.claimUpdate(update.ID) {
+			log.Printf("skipped update %d, it has been processed already\n", update.ID)
 			continue
 		}
 
-		c.processMessage(update.Message)
+		// ignore everything that is not a message or a button press
+		switch {
+		case update.Message != nil:
+			c.processMessage(ctx, update.Message)
+		case update.CallbackQuery != nil:
+			c.processCallback(ctx, update.CallbackQuery)
+		}
 	}
 }
 
-func (c *Client) processMessage(message *Message) {
+func (c *Client) processMessage(ctx context.Context, message *Message) {
 	worker := c.handleWorker(message.Chat.ID)
 
 	log.Printf("got message %s%v%s from %s%d%s", Magenta, message.Text, Reset, Green, message.Chat.ID, Reset)
 
 	switch {
 	case strings.HasPrefix(message.Text, "/"):
-		c.processCommand(message.Text, worker)
+		c.processCommand(ctx, message.Text, worker)
+
+	case c.dialog(worker.ChatId()) != nil:
+		c.continueDialog(ctx, worker, c.dialog(worker.ChatId()), message.Text)
 
 	case c.reAdd.MatchString(message.Text):
 		// adding new query to the wr
 		match := c.reAdd.FindStringSubmatch(message.Text)[0]
 		// handle possible error
 		if err := worker.HandleAddQuery(match); err != nil {
-			c.SendMessage(worker.ChatId(), e.WrapIfErr("error adding query", err).Error())
+			c.replyError(worker.ChatId(), "error adding query", err)
 		} else {
-			c.SendMessage(worker.ChatId(), "Query added 👌🏻")
+			c.reply(worker.ChatId(), "Query added 👌🏻")
 		}
 
-	case c.reRemove.MatchString(message.Text):
-		match := c.reRemove.FindStringSubmatch(message.Text)[0]
-		// handle possible error
-		if err := worker.RemoveQuery(match); err != nil {
-			c.SendMessage(worker.ChatId(), e.WrapIfErr("error removing query", err).Error())
+	case c.reFeed.MatchString(message.Text):
+		match := c.reFeed.FindString(message.Text)
+		if err := worker.HandleAddFeed(match); err != nil {
+			c.replyError(worker.ChatId(), "error adding feed", err)
 		} else {
-			c.SendMessage(worker.ChatId(), "Query removed 🗑️")
+			c.reply(worker.ChatId(), "Feed added 👌🏻")
 		}
 
+	case c.reRemove.MatchString(message.Text):
+		match := c.reRemove.FindString(message.Text)
+		c.removeQuery(ctx, worker, strings.TrimPrefix(match, "remove: "))
+
 	default:
-		// just mirror for now
-		c.SendMessage(worker.ChatId(), message.Text)
+		c.reply(worker.ChatId(), messageUnknown)
+	}
+}
+
+// ResumeWorkers restores the workers that were running before the restart
+func (c *Client) ResumeWorkers(ctx context.Context) {
+	chatIds, err := c.storage.ChatIDs()
+	if err != nil {
+		log.Println(e.WrapIfErr("couldn't resume workers", err).Error())
+		return
+	}
+
+	resumed := 0
+	for _, chatId := range chatIds {
+		worker := c.handleWorker(chatId)
+		if worker.Settings().Working && len(worker.Queries()) > 0 {
+			worker.Work(ctx)
+			resumed++
+		}
+	}
+
+	log.Printf("resumed %s%d%s of %d workers", Magenta, resumed, Reset, len(chatIds))
+}
+
+// Flush saves the state of all the workers, it is called on shutdown when no updates are processed anymore
+func (c *Client) Flush() {
+	c.workersMux.Lock()
+	defer c.workersMux.Unlock()
+
+	for _, worker := range c.workers {
+		worker.Flush()
 	}
 }
 
 func (c *Client) handleWorker(chatId int) Worker {
+	c.workersMux.Lock()
+	defer c.workersMux.Unlock()
+
 	worker, ok := c.workers[chatId]
 	if !ok {
-		worker = NewWorkingAgent(chatId, c.interval, c.storage, c, c.hhClient)
+		worker = NewWorkingAgent(chatId, c.intervals, c.storage, c, c.searcher, c.resolver, c.scheduler)
 		c.workers[chatId] = worker
 		log.Printf("new worker created %s%d%s", Green, chatId, Reset)
 	}
 	return worker
 }
 
-func (c *Client) processCommand(command string, worker Worker) {
-	switch command {
+// updateSetting applies the command argument with set, showing the current settings if there is no argument
+func (c *Client) updateSetting(ctx context.Context, worker Worker, args string, set func(string) error) {
+	if args == "" {
+		c.reply(worker.ChatId(), "Current settings: "+describeSettings(worker.Settings())+"\n\n"+messageSettings)
+		return
+	}
 
-	case "/check":
-		for _, q := range worker.Queries() {
-			worker.DoSearch(q)
-		}
-		c.SendMessage(worker.ChatId(), fmt.Sprintf("Checked %d queries 👌🏻", len(worker.Queries())))
-
-	case "/start":
-		if len(worker.Queries()) == 0 {
-			c.SendMessage(worker.ChatId(), messageNoQueries+"\n\n"+messageAddQuery)
-		} else if !worker.IsWorking() {
-			go worker.Work()
-			c.SendMessage(worker.ChatId(), "Worker started!")
-		}
+	if err := set(args); err != nil {
+		c.replyError(worker.ChatId(), "error updating settings", err)
+		return
+	}
 
-	case "/stop":
-		if worker.IsWorking() {
-			worker.StopWorking()
-			c.SendMessage(worker.ChatId(), "Worker stopped.")
-		}
+	c.reply(worker.ChatId(), "Settings updated: "+describeSettings(worker.Settings()))
+}
 
-	case "/help":
-		c.SendMessage(worker.ChatId(), messageHelp)
+// SendMessage returns once the message is delivered, the outbox doesn't persist it,
+// so retrying is up to the caller
+func (c *Client) SendMessage(ctx context.Context, chatId int, text string) error {
+	return c.send(ctx, chatId, text, nil)
+}
 
-	case "/queries":
-		if queries := worker.Queries(); len(queries) > 0 {
-			c.SendMessage(worker.ChatId(), "Active queries:")
-			for i, q := range queries {
-				msg := fmt.Sprintf("%d – area: <i>%s</i>, role: <i>%s</i>, text: <i>%s</i>, experience: <i>%s</i>;", i+1, q.Area, q.Role, q.Text, q.Experience)
-				c.SendMessage(worker.ChatId(), msg)
-			}
-		} else {
-			c.SendMessage(worker.ChatId(), messageNoQueries)
-		}
+func (c *Client) SendMessageWithKeyboard(ctx context.Context, chatId int, text string, keyboard InlineKeyboardMarkup) error {
+	return c.send(ctx, chatId, text, keyboard)
+}
 
-	case "/status":
-		if worker.IsWorking() {
-			msg := fmt.Sprintf("Working on %d queries with interval %v", len(worker.Queries()), c.interval)
-			c.SendMessage(worker.ChatId(), msg)
-		} else {
-			c.SendMessage(worker.ChatId(), "Worker not started.")
-		}
+func (c *Client) send(ctx context.Context, chatId int, text string, markup any) (err error) {
+	defer func() { err = e.WrapIfErr("couldn't send message", err) }()
 
-	default:
-		c.SendMessage(worker.ChatId(), "Unknown command")
+	m, err := newOutgoingMessage(chatId, text, markup)
+	if err != nil {
+		return err
+	}
+
+	return c.outbox.deliver(ctx, m)
+}
+
+// reply queues the text without waiting for the delivery, the outbox keeps it until it is delivered,
+// even across restarts
+func (c *Client) reply(chatId int, text string) {
+	c.replyWithMarkup(chatId, text, nil)
+}
+
+// replyError explains what went wrong, the errors often quote the user input, so they are escaped
+func (c *Client) replyError(chatId int, msg string, err error) {
+	c.reply(chatId, errorText(msg, err))
+}
+
+func errorText(msg string, err error) string {
+	return html.EscapeString(e.WrapIfErr(msg, err).Error())
+}
+
+// replyWithMarkup is reply with any of the reply markups, nil keeps the current one
+func (c *Client) replyWithMarkup(chatId int, text string, markup any) {
+	m, err := newOutgoingMessage(chatId, text, markup)
+	if err != nil {
+		log.Println(e.WrapIfErr("couldn't send message", err).Error())
+		return
+	}
+
+	c.outbox.push(m)
+}
+
+func newOutgoingMessage(chatId int, text string, markup any) (storage.OutgoingMessage, error) {
+	m := storage.OutgoingMessage{ChatID: chatId, Text: text, QueuedAt: time.Now()}
+
+	if markup != nil {
+		data, err := json.Marshal(markup)
+		if err != nil {
+			return m, err
+		}
+		m.Markup = string(data)
 	}
+
+	return m, nil
 }
 
-func (c *Client) SendMessage(chatId int, text string) {
+// RunOutbox sends the queued messages until ctx is cancelled
+func (c *Client) RunOutbox(ctx context.Context) {
+	c.outbox.run(ctx)
+}
 
+func (c *Client) deliver(ctx context.Context, m storage.OutgoingMessage) error {
 	query := url.Values{
-		"chat_id":    []string{strconv.Itoa(chatId)},
-		"text":       []string{text},
+		"chat_id":    []string{strconv.Itoa(m.ChatID)},
+		"text":       []string{m.Text},
 		"parse_mode": []string{"HTML"},
 	}
-
-	if _, err := c.doRequest(methodSendMessage, query); err != nil {
-		log.Println("couldn't send message", err)
+	if m.Markup != "" {
+		query.Set("reply_markup", m.Markup)
 	}
+
+	return c.call(ctx, methodSendMessage, query)
 }
 
-func (c *Client) doRequest(method string, query url.Values) (data []byte, err error) {
+func (c *Client) doRequest(ctx context.Context, method string, query url.Values) (data []byte, err error) {
 	defer func() { err = e.WrapIfErr("cannot do request", err) }()
 
 	// https://api.telegram.org/bot<token>/METHOD_NAME
@@ -235,12 +366,13 @@ func (c *Client) doRequest(method string, query url.Values) (data []byte, err er
 		Path:   path.Join(c.basePath, method),
 	}
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, requestUrl.String(), nil)
+	// the parameters go in the body, since the messages can be too long for a url
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestUrl.String(), strings.NewReader(query.Encode()))
 	if err != nil {
 		return nil, err
 	}
 
-	req.URL.RawQuery = query.Encode()
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	resp, err := c.tgClient.Do(req)
 	if err != nil {
@@ -255,3 +387,22 @@ func (c *Client) doRequest(method string, query url.Values) (data []byte, err er
 
 	return data, nil
 }
+
+// call does a request to a method that has no result worth reading
+func (c *Client) call(ctx context.Context, method string, query url.Values) error {
+	data, err := c.doRequest(ctx, method, query)
+	if err != nil {
+		return err
+	}
+
+	var res Response
+	if err = json.Unmarshal(data, &res); err != nil {
+		return err
+	}
+
+	if !res.Ok {
+		return newAPIError(method, res)
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package tg
+
+import (
+	"app/internal/lib/e"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strconv"
+)
+
+func (c *Client) registerCommands() {
+	c.router.register("add", "", "add a search query step by step", func(ctx context.Context, worker Worker, _ string) {
+		c.startDialog(ctx, worker)
+	})
+	c.router.register("cancel", "", "cancel adding the query", func(ctx context.Context, worker Worker, _ string) {
+		c.cancelDialog(ctx, worker)
+	})
+	c.router.register("queries", "", "list the search queries", c.handleQueries)
+	c.router.register("remove", "[query_id]", "remove a search query", c.handleRemove)
+	c.router.register("check", "[query_id]", "search for new vacancies right now, for all the queries or a single one", c.handleCheck)
+	c.router.register("start", "", "start searching on schedule", c.handleStart)
+	c.router.register("stop", "", "stop searching", c.handleStop)
+	c.router.register("status", "", "show whether the search is running", c.handleStatus)
+	c.router.register("interval", "[query_id] [interval]", "show or set how often to search", c.handleInterval)
+	c.router.register("timezone", "[name]", "show or set the timezone", func(ctx context.Context, worker Worker, args string) {
+		c.updateSetting(ctx, worker, args, worker.SetTimezone)
+	})
+	c.router.register("hours", "[from-to]", "show or set the active hours", func(ctx context.Context, worker Worker, args string) {
+		c.updateSetting(ctx, worker, args, worker.SetHours)
+	})
+	c.router.register("days", "[days]", "show or set the active days", func(ctx context.Context, worker Worker, args string) {
+		c.updateSetting(ctx, worker, args, worker.SetWeekdays)
+	})
+	c.router.register("help", "", "show this help", func(ctx context.Context, worker Worker, _ string) {
+		c.reply(worker.ChatId(), c.help())
+	})
+}
+
+func (c *Client) processCommand(ctx context.Context, text string, worker Worker) {
+	name, args, forUs := parseCommand(text, c.username)
+	if !forUs {
+		return
+	}
+
+	cmd, ok := c.router.lookup(name)
+	if !ok {
+		c.reply(worker.ChatId(), "Unknown command, see /help")
+		return
+	}
+
+	cmd.handler(ctx, worker, args)
+}
+
+func (c *Client) help() string {
+	return messageIntro + "\n\n" + c.router.help() + "\n\n" + messageAddQuery + "\n\n" + messageAddFeed
+}
+
+// PublishCommands shows the commands in the bot menu, it also learns the bot username,
+// so that the commands addressed to other bots are ignored in group chats
+func (c *Client) PublishCommands(ctx context.Context) (err error) {
+	defer func() { err = e.WrapIfErr("couldn't publish commands", err) }()
+
+	data, err := c.doRequest(ctx, methodGetMe, nil)
+	if err != nil {
+		return err
+	}
+
+	var me UserResponse
+	if err = json.Unmarshal(data, &me); err != nil {
+		return err
+	}
+	if !me.Ok {
+		return fmt.Errorf("%s failed: %s", methodGetMe, me.Description)
+	}
+	c.username = me.Result.Username
+
+	commands := make([]BotCommand, len(c.router.commands))
+	for i, cmd := range c.router.commands {
+		commands[i] = BotCommand{Command: cmd.name, Description: cmd.description}
+	}
+
+	encoded, err := json.Marshal(commands)
+	if err != nil {
+		return err
+	}
+
+	if err = c.call(ctx, methodSetMyCommands, url.Values{"commands": []string{string(encoded)}}); err != nil {
+		return err
+	}
+
+	log.Printf("published %s%d%s commands of @%s\n", Magenta, len(commands), Reset, c.username)
+	return nil
+}
+
+func (c *Client) handleQueries(ctx context.Context, worker Worker, _ string) {
+	queries := worker.Queries()
+	if len(queries) == 0 {
+		c.reply(worker.ChatId(), messageNoQueries)
+		return
+	}
+
+	c.reply(worker.ChatId(), "Active queries:")
+	for i, q := range queries {
+		msg := fmt.Sprintf("%d – %s, interval: <i>%v</i>;", i+1, describeQuery(q, c.resolver), worker.QueryInterval(q))
+		c.reply(worker.ChatId(), msg)
+	}
+}
+
+func (c *Client) handleRemove(ctx context.Context, worker Worker, args string) {
+	if args == "" {
+		c.reply(worker.ChatId(), "Which query to remove? See /queries for the ids, e.g. <code>/remove 2</code>")
+		return
+	}
+
+	c.removeQuery(ctx, worker, args)
+}
+
+// removeQuery is shared by /remove and the "remove:" message
+func (c *Client) removeQuery(ctx context.Context, worker Worker, args string) {
+	queryId, err := strconv.Atoi(args)
+	if err == nil {
+		err = worker.RemoveQuery(queryId)
+	}
+
+	if err != nil {
+		c.replyError(worker.ChatId(), "error removing query", err)
+	} else {
+		c.reply(worker.ChatId(), "Query removed 🗑️")
+	}
+}
+
+func (c *Client) handleCheck(ctx context.Context, worker Worker, args string) {
+	queryId := 0
+	if args != "" {
+		var err error
+		if queryId, err = strconv.Atoi(args); err != nil || queryId < 1 {
+			c.reply(worker.ChatId(), fmt.Sprintf("invalid query id %s", html.EscapeString(args)))
+			return
+		}
+	}
+
+	if err := worker.DoSearch(ctx, queryId); err != nil {
+		c.replyError(worker.ChatId(), "error checking queries", err)
+		return
+	}
+
+	if queryId > 0 {
+		c.reply(worker.ChatId(), fmt.Sprintf("Checked query %d 👌🏻", queryId))
+	} else {
+		c.reply(worker.ChatId(), fmt.Sprintf("Checked %d queries 👌🏻", len(worker.Queries())))
+	}
+}
+
+func (c *Client) handleStart(ctx context.Context, worker Worker, _ string) {
+	if len(worker.Queries()) == 0 {
+		c.reply(worker.ChatId(), messageNoQueries+"\n\n"+messageAddQuery)
+	} else if !worker.IsWorking() {
+		worker.Work(ctx)
+		c.reply(worker.ChatId(), "Worker started!")
+	}
+}
+
+func (c *Client) handleStop(ctx context.Context, worker Worker, _ string) {
+	if worker.IsWorking() {
+		worker.StopWorking()
+		c.reply(worker.ChatId(), "Worker stopped.")
+	}
+}
+
+func (c *Client) handleStatus(ctx context.Context, worker Worker, _ string) {
+	if worker.IsWorking() {
+		msg := fmt.Sprintf("Working on %d queries with interval %v, %s", len(worker.Queries()), worker.Interval(), describeSettings(worker.Settings()))
+		c.reply(worker.ChatId(), msg)
+	} else {
+		c.reply(worker.ChatId(), "Worker not started.")
+	}
+}
+
+func (c *Client) handleInterval(ctx context.Context, worker Worker, args string) {
+	if args == "" {
+		msg := fmt.Sprintf("Current interval: %v, allowed from %v to %v\n\n%s", worker.Interval(), c.intervals.Min, c.intervals.Max, messageInterval)
+		c.reply(worker.ChatId(), msg)
+	} else if err := worker.SetInterval(args); err != nil {
+		c.replyError(worker.ChatId(), "error updating interval", err)
+	} else {
+		c.reply(worker.ChatId(), "Interval updated 👌🏻")
+	}
+}
@@ -0,0 +1,219 @@
+package tg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type dialogStep int
+
+const (
+	stepArea dialogStep = iota
+	stepRole
+	stepKeywords
+	stepExperience
+	stepSalary
+	stepConfirm
+)
+
+const (
+	choiceSkip   = "Skip"
+	choiceSave   = "Save"
+	choiceCancel = "/cancel"
+)
+
+// experienceChoices are ordered as shown on the keyboard
+var experienceChoices = []struct{ text, id string }{
+	{"No experience", "noExperience"},
+	{"1-3 years", "between1And3"},
+	{"3-6 years", "between3And6"},
+	{"6+ years", "moreThan6"},
+	{"Any", ""},
+}
+
+// dialog is the state of the /add wizard of a chat, the query is filled step by step
+type dialog struct {
+	step  dialogStep
+	query Query
+}
+
+func (c *Client) startDialog(ctx context.Context, worker Worker) {
+	d := &dialog{step: stepArea}
+
+	c.dialogsMux.Lock()
+	c.dialogs[worker.ChatId()] = d
+	c.dialogsMux.Unlock()
+
+	c.promptDialog(ctx, worker, d)
+}
+
+func (c *Client) cancelDialog(ctx context.Context, worker Worker) {
+	if c.endDialog(worker.ChatId()) == nil {
+		c.reply(worker.ChatId(), "Nothing to cancel.")
+		return
+	}
+	c.replyWithMarkup(worker.ChatId(), "Cancelled.", ReplyKeyboardRemove{RemoveKeyboard: true})
+}
+
+func (c *Client) dialog(chatId int) *dialog {
+	c.dialogsMux.Lock()
+	defer c.dialogsMux.Unlock()
+
+	return c.dialogs[chatId]
+}
+
+// endDialog returns the dialog that has been ended, if there was one
+func (c *Client) endDialog(chatId int) *dialog {
+	c.dialogsMux.Lock()
+	defer c.dialogsMux.Unlock()
+
+	d := c.dialogs[chatId]
+	delete(c.dialogs, chatId)
+	return d
+}
+
+// continueDialog applies the answer to the current step and asks the next question,
+// the same question is asked again if the answer is invalid
+func (c *Client) continueDialog(ctx context.Context, worker Worker, d *dialog, answer string) {
+	answer = strings.TrimSpace(answer)
+
+	if err := c.applyAnswer(d, answer); err != nil {
+		c.replyError(worker.ChatId(), "invalid answer", err)
+		c.promptDialog(ctx, worker, d)
+		return
+	}
+
+	if d.step != stepConfirm || answer != choiceSave {
+		c.promptDialog(ctx, worker, d)
+		return
+	}
+
+	c.endDialog(worker.ChatId())
+
+	msg := "Query added 👌🏻"
+	if err := worker.AddQuery(d.query); err != nil {
+		msg = errorText("error adding query", err)
+	} else if !worker.IsWorking() {
+		msg += " Send /start to start searching."
+	}
+	c.replyWithMarkup(worker.ChatId(), msg, ReplyKeyboardRemove{RemoveKeyboard: true})
+}
+
+func (c *Client) applyAnswer(d *dialog, answer string) (err error) {
+	switch d.step {
+	case stepArea:
+		if d.query.Areas, err = resolveAll(answer, c.resolver.ResolveArea); err != nil {
+			return err
+		}
+
+	case stepRole:
+		if d.query.Roles, err = resolveAll(answer, c.resolver.ResolveRole); err != nil {
+			return err
+		}
+
+	case stepKeywords:
+		if answer == "" {
+			return errors.New("keywords can't be empty")
+		}
+		d.query.Text = answer
+
+	case stepExperience:
+		found := false
+		for _, choice := range experienceChoices {
+			if strings.EqualFold(choice.text, answer) {
+				d.query.Experience, found = choice.id, true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown experience %q, choose one of the options", answer)
+		}
+
+	case stepSalary:
+		if strings.EqualFold(answer, choiceSkip) {
+			d.query.Salary = 0
+			break
+		}
+		salary, err := strconv.Atoi(strings.Join(strings.Fields(answer), ""))
+		if err != nil || salary <= 0 {
+			return fmt.Errorf("salary should be a positive number, got: %s", answer)
+		}
+		d.query.Salary = salary
+
+	case stepConfirm:
+		if answer != choiceSave {
+			return fmt.Errorf("send %s to save the query or %s to drop it", choiceSave, choiceCancel)
+		}
+		return nil
+	}
+
+	d.step++
+	return nil
+}
+
+func (c *Client) promptDialog(ctx context.Context, worker Worker, d *dialog) {
+	var (
+		text    string
+		choices []string
+	)
+
+	switch d.step {
+	case stepArea:
+		text = "Which area to search in? Send a name or an id, several ones can be separated by commas."
+		choices = []string{"Москва", "Санкт-Петербург", "Россия"}
+	case stepRole:
+		text = "Which professional role? Send a name or an id, several ones can be separated by commas."
+		choices = []string{"Программист, разработчик", "Тестировщик", "DevOps-инженер", "Аналитик"}
+	case stepKeywords:
+		text = "Which keywords should the vacancies contain? E.g. <code>golang developer</code>"
+	case stepExperience:
+		text = "How much experience?"
+		for _, choice := range experienceChoices {
+			choices = append(choices, choice.text)
+		}
+	case stepSalary:
+		text = "What is the minimal salary in rubles? Send a number or skip it."
+		choices = []string{choiceSkip}
+	case stepConfirm:
+		text = "Save the query?\n" + describeQuery(d.query, c.resolver)
+		choices = []string{choiceSave}
+	}
+
+	c.replyWithMarkup(worker.ChatId(), text, replyKeyboard(append(choices, choiceCancel)...))
+}
+
+// resolveAll resolves the answer as a single name first, since the names may contain commas themselves,
+// and as a comma-separated list otherwise
+func resolveAll(answer string, resolve func(string) (string, error)) ([]string, error) {
+	id, err := resolve(answer)
+	if err == nil {
+		return []string{id}, nil
+	}
+	if !strings.Contains(answer, ",") {
+		return nil, err
+	}
+
+	ids := make([]string, 0)
+	for _, name := range strings.Split(answer, ",") {
+		if id, err = resolve(strings.TrimSpace(name)); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// replyKeyboard lays the choices out in rows of two
+func replyKeyboard(choices ...string) ReplyKeyboardMarkup {
+	rows := make([][]KeyboardButton, 0, (len(choices)+1)/2)
+	for i := 0; i < len(choices); i += 2 {
+		row := []KeyboardButton{{Text: choices[i]}}
+		if i+1 < len(choices) {
+			row = append(row, KeyboardButton{Text: choices[i+1]})
+		}
+		rows = append(rows, row)
+	}
+	return ReplyKeyboardMarkup{Keyboard: rows, ResizeKeyboard: true, OneTimeKeyboard: true}
+}
@@ -1,5 +1,30 @@
 package tg
 
+// Response is the part every method responds with
+type Response struct {
+	Ok          bool                `json:"ok"`
+	Description string              `json:"description,omitempty"`
+	ErrorCode   int                 `json:"error_code,omitempty"`
+	Parameters  *ResponseParameters `json:"parameters,omitempty"`
+}
+
+// ResponseParameters describe why a request failed
+type ResponseParameters struct {
+	RetryAfter int `json:"retry_after,omitempty"` // seconds left to wait before the request can be repeated
+}
+
+type UserResponse struct {
+	Ok          bool   `json:"ok"`
+	Description string `json:"description,omitempty"`
+	Result      User   `json:"result"`
+}
+
+// BotCommand is an entry of the bot menu
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
 type UpdatesResponse struct {
 	Ok          bool     `json:"ok"`
 	Description string   `json:"description,omitempty"`
@@ -7,15 +32,50 @@ type UpdatesResponse struct {
 }
 
 type Update struct {
-	ID      int      `json:"update_id"`
-	Message *Message `json:"message"` // Optional. New incoming message of any kind - text, photo, sticker, etc.
+	ID            int            `json:"update_id"`
+	Message       *Message       `json:"message"`        // Optional. New incoming message of any kind - text, photo, sticker, etc.
+	CallbackQuery *CallbackQuery `json:"callback_query"` // Optional. New incoming callback query
 	// at most one of the optional parameters can be present in any given update
 }
 
 type Message struct {
+	MessageID   int                   `json:"message_id"`
+	Text        string                `json:"text"`
+	From        User                  `json:"from"`
+	Chat        Chat                  `json:"chat"`
+	ReplyMarkup *InlineKeyboardMarkup `json:"reply_markup"`
+}
+
+// CallbackQuery is sent when a button of an inline keyboard is pressed
+type CallbackQuery struct {
+	ID      string   `json:"id"`
+	From    User     `json:"from"`
+	Message *Message `json:"message"` // Optional. Missing if the message is too old
+	Data    string   `json:"data"`
+}
+
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// ReplyKeyboardMarkup replaces the keyboard of the user with the choices
+type ReplyKeyboardMarkup struct {
+	Keyboard        [][]KeyboardButton `json:"keyboard"`
+	ResizeKeyboard  bool               `json:"resize_keyboard"`
+	OneTimeKeyboard bool               `json:"one_time_keyboard"`
+}
+
+type KeyboardButton struct {
 	Text string `json:"text"`
-	From User   `json:"from"`
-	Chat Chat   `json:"chat"`
+}
+
+type ReplyKeyboardRemove struct {
+	RemoveKeyboard bool `json:"remove_keyboard"`
+}
+
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"` // 1-64 bytes
 }
 
 type User struct {
@@ -28,10 +88,3 @@ type Chat struct {
 	ID   int    `json:"id"`
 	Type string `json:"type"`
 }
-
-type Query struct {
-	Area       string
-	Role       string
-	Text       string
-	Experience string
-}
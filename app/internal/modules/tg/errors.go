@@ -0,0 +1,39 @@
+package tg
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// APIError is returned when telegram responds with ok:false
+type APIError struct {
+	Method      string
+	Code        int
+	Description string
+	RetryAfter  time.Duration // set for 429 Too Many Requests
+}
+
+func newAPIError(method string, res Response) *APIError {
+	err := &APIError{Method: method, Code: res.ErrorCode, Description: res.Description}
+	if res.Parameters != nil {
+		err.RetryAfter = time.Duration(res.Parameters.RetryAfter) * time.Second
+	}
+	return err
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s failed with %d: %s", e.Method, e.Code, e.Description)
+}
+
+// temporary reports whether repeating the request may succeed, the client errors,
+// like a chat that blocked the bot, won't go away by themselves
+func (e *APIError) temporary() bool {
+	return e.Code == 429 || e.Code >= 500
+}
+
+// isPermanent reports whether telegram rejected the request for good, so there is no point in repeating it
+func isPermanent(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && !apiErr.temporary()
+}
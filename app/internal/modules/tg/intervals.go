@@ -0,0 +1,54 @@
+package tg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Intervals are the admin-configured search intervals, chats and queries may override
+// the default one within the bounds
+type Intervals struct {
+	Default time.Duration
+	Min     time.Duration
+	Max     time.Duration
+}
+
+func (i Intervals) clamp(d time.Duration) time.Duration {
+	return min(max(d, i.Min), i.Max)
+}
+
+func (i Intervals) validate(d time.Duration) error {
+	if d < i.Min || d > i.Max {
+		return fmt.Errorf("interval should be between %v and %v", i.Min, i.Max)
+	}
+	return nil
+}
+
+// parseIntervalArgs parses "[query_id] (duration|default)", queryId is 0 when the interval is set for the whole chat
+// and the duration is 0 when the interval should be reset to default
+func parseIntervalArgs(args string) (queryId int, interval time.Duration, err error) {
+	parts := strings.Fields(args)
+
+	switch len(parts) {
+	case 1:
+	case 2:
+		if queryId, err = strconv.Atoi(parts[0]); err != nil || queryId < 1 {
+			return 0, 0, fmt.Errorf("invalid query id %s", parts[0])
+		}
+		parts = parts[1:]
+	default:
+		return 0, 0, fmt.Errorf("should be 1 or 2 arguments, got: %d", len(parts))
+	}
+
+	if parts[0] == "default" {
+		return queryId, 0, nil
+	}
+
+	if interval, err = time.ParseDuration(parts[0]); err != nil || interval <= 0 {
+		return 0, 0, fmt.Errorf("invalid interval %s, use values like 30m or 2h", parts[0])
+	}
+
+	return queryId, interval, nil
+}
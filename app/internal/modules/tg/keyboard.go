@@ -0,0 +1,73 @@
+package tg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the actions are kept short, since the callback data is limited to 64 bytes
+const (
+	actionNotInterested = "ni"
+	actionHideEmployer  = "he"
+	actionSave          = "sv"
+	actionApplied       = "ap"
+)
+
+var actionNames = map[string]string{
+	actionNotInterested: "Not interested",
+	actionHideEmployer:  "Hide employer",
+	actionSave:          "Save",
+	actionApplied:       "Applied",
+}
+
+const markChosen = "✅ "
+
+// vacancyKeyboard attaches the decision buttons to a notification, the employer can't be hidden
+// if the source doesn't identify it
+func vacancyKeyboard(vacancyKey, employerKey string) InlineKeyboardMarkup {
+	rows := [][]InlineKeyboardButton{{
+		button(actionNotInterested, vacancyKey),
+		button(actionSave, vacancyKey),
+		button(actionApplied, vacancyKey),
+	}}
+
+	if employerKey != "" {
+		rows = append(rows, []InlineKeyboardButton{button(actionHideEmployer, employerKey)})
+	}
+
+	return InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+func button(action, key string) InlineKeyboardButton {
+	return InlineKeyboardButton{Text: actionNames[action], CallbackData: action + ":" + key}
+}
+
+// parseCallbackData splits "action:key", the key itself contains colons
+func parseCallbackData(data string) (action, key string, err error) {
+	action, key, ok := strings.Cut(data, ":")
+	if _, known := actionNames[action]; !ok || !known || key == "" {
+		return "", "", fmt.Errorf("unknown callback data %q", data)
+	}
+	return action, key, nil
+}
+
+// markDecision marks the pressed button, the vacancy buttons are mutually exclusive,
+// so the mark is removed from the other ones
+func markDecision(keyboard InlineKeyboardMarkup, data string) InlineKeyboardMarkup {
+	rows := make([][]InlineKeyboardButton, len(keyboard.InlineKeyboard))
+	for i, row := range keyboard.InlineKeyboard {
+		rows[i] = make([]InlineKeyboardButton, len(row))
+		for j, b := range row {
+			action, _, _ := strings.Cut(b.CallbackData, ":")
+
+			switch {
+			case b.CallbackData == data:
+				b.Text = markChosen + strings.TrimPrefix(b.Text, markChosen)
+			case action != actionHideEmployer:
+				b.Text = strings.TrimPrefix(b.Text, markChosen)
+			}
+			rows[i][j] = b
+		}
+	}
+	return InlineKeyboardMarkup{InlineKeyboard: rows}
+}
@@ -3,12 +3,30 @@ package tg
 // formatting options:
 // https://core.telegram.org/bots/api#formatting-options
 
-const messageHelp = "This bot helps you to find new vacancies on hh.ru based on your search queries.\n\n" +
-	messageAddQuery + "\n\n" + messageRemoveQuery
+const messageIntro = "This bot helps you to find new vacancies on hh.ru based on your search queries."
 
-const messageAddQuery = `To add a new query, send a message to the bot in the following format: <b>add: [area: int] [role_id: int] [keywords: string] [experience: (-|0|1-3|3-6|6)]</b>
-Example: <code>add: 1 96 golang-разработчик 1-3</code>`
+const messageAddQuery = `To add a new query step by step, send <b>/add</b>, <b>/cancel</b> stops it at any step.
 
-const messageRemoveQuery = `To delete one of the queries, send the following: <b>remove: [query_id: int]</b>`
+Or send a message to the bot in the following format: <b>add: [areas: id|name,...] [roles: id|name,...] [keywords: string] [experience: (-|0|1-3|3-6|6)] [option=value ...]</b>
+Example: <code>add: 1 96 golang-разработчик 1-3</code>
+
+Areas and roles can also be given by name, use _ instead of spaces and commas to separate several values.
+Example: <code>add: Москва,Нижний_Новгород Программист golang 1-3</code>
+
+Available options: <b>salary</b>, <b>currency</b>, <b>only_with_salary</b> (true|false), <b>schedule</b>, <b>employment</b>, <b>search_field</b>, <b>order_by</b>, <b>label</b>; list values are comma-separated.
+Example: <code>add: 1,2 96 golang 3-6 salary=300000 only_with_salary=true schedule=remote,flexible</code>`
+
+const messageAddFeed = `To follow an RSS or Atom job feed, send: <b>feed: [url] [keywords: string]</b>, the keywords are optional
+Example: <code>feed: https://example.com/jobs.rss golang remote</code>`
+
+const messageSettings = `Searches run around the clock, but notifications are delivered only during the active hours, the ones found during the quiet hours are sent once the active hours start.
+<b>/timezone [name]</b> – set the timezone, e.g. <code>/timezone Europe/Moscow</code>
+<b>/hours [from-to]</b> – set the active hours, e.g. <code>/hours 9-21</code>
+<b>/days [days]</b> – set the active days, e.g. <code>/days mon-fri</code> or <code>/days all</code>`
+
+const messageInterval = `<b>/interval [query_id] [interval]</b> – set how often to search for the whole chat or for a single query, use <i>default</i> to reset it
+Example: <code>/interval 30m</code> or <code>/interval 2 2h</code>`
 
 const messageNoQueries = "No active queries found."
+
+const messageUnknown = "Sorry, I didn't get that. Send /add to add a query or /help to see what I can do."
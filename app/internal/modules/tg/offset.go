@@ -0,0 +1,68 @@
+package tg
+
+import (
+	"app/internal/lib/e"
+	"app/internal/storage"
+	"log"
+	"slices"
+)
+
+// maxProcessed bounds the processed updates in webhook mode, where nothing gets confirmed
+const maxProcessed = 1000
+
+// restoreOffset continues from where the bot stopped, so that the updates aren't handled twice
+func (c *Client) restoreOffset() {
+	offset, err := c.storage.ReadOffset()
+	if err != nil {
+		log.Println(e.WrapIfErr("couldn't restore offset", err).Error())
+		return
+	}
+	if offset == nil {
+		return
+	}
+
+	c.offset, c.confirmed, c.processed = offset.Confirmed, offset.Confirmed, offset.Processed
+	log.Printf("restored offset %s%d%s with %d processed updates\n", Magenta, c.confirmed, Reset, len(c.processed))
+}
+
+// claimUpdate marks the update as processed before it is handled, a webhook update is delivered again
+// if handling it takes longer than telegram waits, and it must not be handled twice meanwhile
+func (c *Client) claimUpdate(updateId int) bool {
+	c.offsetMux.Lock()
+	defer c.offsetMux.Unlock()
+
+	if updateId < c.confirmed || slices.Contains(c.processed, updateId) {
+		return false
+	}
+
+	c.processed = append(c.processed, updateId)
+	if len(c.processed) > maxProcessed {
+		c.processed = slices.Clone(c.processed[len(c.processed)-maxProcessed:])
+	}
+
+	c.saveOffset()
+	return true
+}
+
+// confirmOffset forgets the processed updates that telegram won't deliver anymore
+func (c *Client) confirmOffset(offset int) {
+	c.offsetMux.Lock()
+	defer c.offsetMux.Unlock()
+
+	if offset <= c.confirmed {
+		return
+	}
+
+	c.confirmed = offset
+	c.processed = slices.DeleteFunc(c.processed, func(id int) bool { return id < offset })
+
+	c.saveOffset()
+}
+
+// saveOffset writes the offset atomically, the caller must hold the lock
+func (c *Client) saveOffset() {
+	offset := storage.Offset{Confirmed: c.confirmed, Processed: c.processed}
+	if err := c.storage.SaveOffset(offset); err != nil {
+		log.Println(err.Error())
+	}
+}
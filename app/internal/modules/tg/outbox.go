@@ -0,0 +1,278 @@
+package tg
+
+import (
+	"app/internal/storage"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	maxSendAttempts = 5
+	sendBackoff     = time.Second * 2 // doubled with every failed attempt
+	idleWait        = time.Minute     // how long to sleep when there is nothing to send
+)
+
+var errOutboxStopped = errors.New("outbox is stopped")
+
+// outbox queues the outgoing messages, so that bursts from many chats stay within the telegram limits,
+// the messages nobody waits for are persisted on every change, so that they survive a restart
+type outbox struct {
+	mux     *sync.Mutex
+	queue   []*queued
+	wake    chan struct{}
+	global  *tokenBucket
+	chats   map[int]*tokenBucket
+	storage storage.Storage
+	send    func(ctx context.Context, m storage.OutgoingMessage) error
+	stopped bool // run has returned, nobody is going to deliver the messages
+}
+
+type queued struct {
+	storage.OutgoingMessage
+	notBefore time.Time  // backoff after a failed attempt
+	sending   bool       // the message is being sent and can't be withdrawn
+	result    chan error // the caller waiting for the delivery, nil if there is none
+}
+
+func newOutbox(store storage.Storage, send func(ctx context.Context, m storage.OutgoingMessage) error) *outbox {
+	o := &outbox{
+		mux:     new(sync.Mutex),
+		wake:    make(chan struct{}, 1),
+		global:  newTokenBucket(globalRate, globalBurst),
+		chats:   make(map[int]*tokenBucket),
+		storage: store,
+		send:    send,
+	}
+
+	messages, err := store.ReadOutbox()
+	if err != nil {
+		log.Println(err.Error())
+	}
+	for _, m := range messages {
+		o.queue = append(o.queue, &queued{OutgoingMessage: m})
+	}
+	if len(o.queue) > 0 {
+		log.Printf("restored %s%d%s undelivered messages\n", Magenta, len(o.queue), Reset)
+	}
+
+	return o
+}
+
+// push queues the message and leaves it to the outbox
+func (o *outbox) push(m storage.OutgoingMessage) {
+	o.enqueue(&queued{OutgoingMessage: m})
+}
+
+// deliver queues the message and waits for the delivery, the message is withdrawn
+// if ctx is cancelled before it is sent
+func (o *outbox) deliver(ctx context.Context, m storage.OutgoingMessage) error {
+	q := &queued{OutgoingMessage: m, result: make(chan error, 1)}
+	o.enqueue(q)
+
+	select {
+	case err := <-q.result:
+		return err
+	case <-ctx.Done():
+		if o.withdraw(q) {
+			return ctx.Err()
+		}
+		// it's too late, the outbox is sending it already
+		return <-q.result
+	}
+}
+
+func (o *outbox) enqueue(q *queued) {
+	o.mux.Lock()
+	if o.stopped && q.result != nil {
+		o.mux.Unlock()
+		q.result <- errOutboxStopped
+		return
+	}
+
+	o.queue = append(o.queue, q)
+	if q.result == nil {
+		o.save()
+	}
+	o.mux.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *outbox) withdraw(q *queued) bool {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	if q.sending {
+		return false
+	}
+	o.remove(q)
+	return true
+}
+
+// run sends the messages one by one until ctx is cancelled, the ones left stay persisted
+// and the callers still waiting get the error
+func (o *outbox) run(ctx context.Context) {
+	defer o.abandon(ctx)
+
+	for {
+		m, wait := o.next(time.Now())
+		if m == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.wake:
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		err := o.send(ctx, m.OutgoingMessage)
+		if ctx.Err() != nil {
+			return
+		}
+		o.done(m, err, time.Now())
+	}
+}
+
+func (o *outbox) abandon(ctx context.Context) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	o.stopped = true
+	queue := o.queue[:0]
+	for _, m := range o.queue {
+		if m.result != nil {
+			m.result <- ctx.Err()
+			continue
+		}
+		m.sending = false
+		queue = append(queue, m)
+	}
+	o.queue = queue
+}
+
+// next picks the oldest message that can be sent right now, keeping the order of the messages
+// within a chat, or tells how long to wait for one
+func (o *outbox) next(now time.Time) (*queued, time.Duration) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	if len(o.queue) == 0 {
+		o.forgetIdleChats(now)
+		return nil, idleWait
+	}
+
+	wait := idleWait
+	blocked := make(map[int]bool)
+	for _, m := range o.queue {
+		if blocked[m.ChatID] {
+			continue
+		}
+
+		delay := max(o.chat(m.ChatID).delay(now), m.notBefore.Sub(now))
+		if delay > 0 {
+			blocked[m.ChatID] = true
+			wait = min(wait, delay)
+			continue
+		}
+
+		if delay = o.global.delay(now); delay > 0 {
+			return nil, delay
+		}
+
+		o.global.take(now)
+		o.chat(m.ChatID).take(now)
+		m.sending = true
+		return m, 0
+	}
+
+	return nil, wait
+}
+
+// done removes the sent message, or reschedules it if the error is temporary
+func (o *outbox) done(m *queued, err error, now time.Time) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	m.sending = false
+
+	var apiErr *APIError
+	isApiErr := errors.As(err, &apiErr)
+
+	switch {
+	case err == nil:
+	case isApiErr && apiErr.RetryAfter > 0:
+		// telegram limits the chat, the attempt doesn't count
+		o.chat(m.ChatID).pause(now.Add(apiErr.RetryAfter))
+		log.Printf("telegram asked to wait %v before sending to chat %d\n", apiErr.RetryAfter, m.ChatID)
+		return
+	case isApiErr && !apiErr.temporary():
+		log.Printf("dropped message to chat %d: %s\n", m.ChatID, err.Error())
+	case m.result != nil:
+		// the caller retries by itself, there is no point in holding it
+	default:
+		if m.Attempts++; m.Attempts < maxSendAttempts {
+			m.notBefore = now.Add(sendBackoff << (m.Attempts - 1))
+			log.Printf("couldn't send message to chat %d, attempt %d: %s\n", m.ChatID, m.Attempts, err.Error())
+			o.save()
+			return
+		}
+		log.Printf("dropped message to chat %d after %d attempts: %s\n", m.ChatID, m.Attempts, err.Error())
+	}
+
+	o.remove(m)
+	if m.result != nil {
+		m.result <- err
+	}
+}
+
+// remove drops the message from the queue, the caller must hold the lock
+func (o *outbox) remove(m *queued) {
+	for i := range o.queue {
+		if o.queue[i] == m {
+			o.queue = append(o.queue[:i], o.queue[i+1:]...)
+			break
+		}
+	}
+
+	if m.result == nil {
+		o.save()
+	}
+}
+
+func (o *outbox) chat(chatId int) *tokenBucket {
+	b, ok := o.chats[chatId]
+	if !ok {
+		b = newChatBucket(chatId)
+		o.chats[chatId] = b
+	}
+	return b
+}
+
+func (o *outbox) forgetIdleChats(now time.Time) {
+	for chatId, b := range o.chats {
+		if b.idle(now) {
+			delete(o.chats, chatId)
+		}
+	}
+}
+
+// save persists the queue, the caller must hold the lock
+func (o *outbox) save() {
+	messages := make([]storage.OutgoingMessage, 0, len(o.queue))
+	for _, m := range o.queue {
+		if m.result == nil {
+			messages = append(messages, m.OutgoingMessage)
+		}
+	}
+
+	if err := o.storage.SaveOutbox(messages); err != nil {
+		log.Println(err.Error())
+	}
+}
@@ -0,0 +1,199 @@
+package tg
+
+import (
+	"app/internal/modules/hh"
+	"app/internal/modules/source"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Query struct {
+	source.Query
+	Watermark time.Time     // publication time of the newest vacancy seen so far
+	Interval  time.Duration // overrides the chat interval if set
+}
+
+type numberedQuery struct {
+	Query
+	number int // as shown by /queries
+}
+
+type match struct {
+	vacancy source.Vacancy
+	queries []numberedQuery
+}
+
+// parseAddQuery parses a message matched by reAdd:
+// add: [areas] [roles] [text] [experience] [option=value ...]
+func parseAddQuery(regexMatch string) (q Query, err error) {
+	parts := strings.Split(regexMatch, " ")
+	if len(parts) < 5 {
+		return q, fmt.Errorf("should be at least 5 parts, got: %d", len(parts))
+	}
+
+	q.Areas = strings.Split(parts[1], ",")
+	q.Roles = strings.Split(parts[2], ",")
+	q.Text = parts[3]
+	q.Experience = parseExperience(parts[4])
+
+	for _, option := range parts[5:] {
+		if err = q.setOption(option); err != nil {
+			return q, err
+		}
+	}
+
+	return q, nil
+}
+
+// parseAddFeed parses a message matched by reFeed: feed: [url] [keywords ...]
+func parseAddFeed(regexMatch string) (q Query, err error) {
+	parts := strings.SplitN(regexMatch, " ", 3)
+	if len(parts) < 2 {
+		return q, fmt.Errorf("should be at least 2 parts, got: %d", len(parts))
+	}
+
+	feedUrl, err := url.Parse(parts[1])
+	if err != nil || feedUrl.Host == "" || (feedUrl.Scheme != "http" && feedUrl.Scheme != "https") {
+		return q, fmt.Errorf("invalid feed url %s", parts[1])
+	}
+	if err = source.CheckFeedHost(feedUrl.Hostname()); err != nil {
+		return q, err
+	}
+
+	q.Source = source.SourceFeed
+	q.FeedURL = feedUrl.String()
+	if len(parts) == 3 {
+		q.Text = strings.TrimSpace(parts[2])
+	}
+
+	return q, nil
+}
+
+func (q *Query) setOption(option string) (err error) {
+	key, value, ok := strings.Cut(option, "=")
+	if !ok || value == "" {
+		return fmt.Errorf("option %q should look like key=value", option)
+	}
+
+	switch key {
+	case "salary":
+		if q.Salary, err = strconv.Atoi(value); err != nil || q.Salary < 0 {
+			return fmt.Errorf("salary should be a positive number, got: %s", value)
+		}
+	case "only_with_salary":
+		if q.OnlyWithSalary, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("only_with_salary should be true or false, got: %s", value)
+		}
+	case "currency":
+		q.Currency = strings.ToUpper(value)
+	case "schedule":
+		q.Schedules = strings.Split(value, ",")
+	case "employment":
+		q.Employments = strings.Split(value, ",")
+	case "search_field":
+		q.SearchFields = strings.Split(value, ",")
+	case "order_by":
+		q.OrderBy = value
+	case "label":
+		q.Labels = strings.Split(value, ",")
+	default:
+		return errors.New("unknown option " + key)
+	}
+
+	return nil
+}
+
+func parseExperience(exp string) string {
+	switch exp {
+	case "0":
+		return "noExperience"
+	case "1-3":
+		return "between1And3"
+	case "3-6":
+		return "between3And6"
+	case "6":
+		return "moreThan6"
+	default:
+		return ""
+	}
+}
+
+// parseLegacyQuery parses queries stored in the "area role text experience" format
+func parseLegacyQuery(query string) (q Query, err error) {
+	parts := strings.Split(query, " ")
+	if len(parts) != 4 {
+		return q, fmt.Errorf("expected 4 parts, got: %s", query)
+	}
+
+	q.Areas = []string{parts[0]}
+	q.Roles = []string{parts[1]}
+	q.Text = parts[2]
+	q.Experience = parts[3]
+
+	return q, nil
+}
+
+func describeQuery(q Query, resolver hh.Resolver) string {
+	if q.SourceName() == source.SourceFeed {
+		if q.Text == "" {
+			return fmt.Sprintf("feed: <i>%s</i>", html.EscapeString(q.FeedURL))
+		}
+		return fmt.Sprintf("feed: <i>%s</i>, keywords: <i>%s</i>", html.EscapeString(q.FeedURL), html.EscapeString(q.Text))
+	}
+
+	// the names of unknown ids are the ids themselves, which come from the user
+	names := func(ids []string, name func(string) string) string {
+		res := make([]string, len(ids))
+		for i, id := range ids {
+			res[i] = html.EscapeString(name(id))
+		}
+		return strings.Join(res, ", ")
+	}
+	dictNames := func(dictionary string, ids []string) string {
+		return names(ids, func(id string) string { return resolver.DictionaryName(dictionary, id) })
+	}
+
+	experience := "any"
+	if q.Experience != "" {
+		experience = html.EscapeString(resolver.DictionaryName("experience", q.Experience))
+	}
+
+	desc := fmt.Sprintf("area: <i>%s</i>, role: <i>%s</i>, text: <i>%s</i>, experience: <i>%s</i>",
+		names(q.Areas, resolver.AreaName), names(q.Roles, resolver.RoleName), html.EscapeString(q.Text), experience)
+
+	options := make([]string, 0)
+	if q.Salary > 0 {
+		options = append(options, fmt.Sprintf("salary: <i>%s</i>", strings.TrimSpace(fmt.Sprintf("%d %s", q.Salary, html.EscapeString(q.Currency)))))
+	} else if q.Currency != "" {
+		options = append(options, fmt.Sprintf("currency: <i>%s</i>", html.EscapeString(q.Currency)))
+	}
+	if q.OnlyWithSalary {
+		options = append(options, "only with salary")
+	}
+	if len(q.Schedules) > 0 {
+		options = append(options, fmt.Sprintf("schedule: <i>%s</i>", dictNames("schedule", q.Schedules)))
+	}
+	if len(q.Employments) > 0 {
+		options = append(options, fmt.Sprintf("employment: <i>%s</i>", dictNames("employment", q.Employments)))
+	}
+	if len(q.SearchFields) > 0 {
+		options = append(options, fmt.Sprintf("search in: <i>%s</i>", dictNames("vacancy_search_fields", q.SearchFields)))
+	}
+	if q.OrderBy != "" {
+		options = append(options, fmt.Sprintf("order by: <i>%s</i>", html.EscapeString(resolver.DictionaryName("vacancy_search_order", q.OrderBy))))
+	}
+	if len(q.Labels) > 0 {
+		options = append(options, fmt.Sprintf("labels: <i>%s</i>", dictNames("vacancy_label", q.Labels)))
+	}
+
+	if len(options) > 0 {
+		desc += ", " + strings.Join(options, ", ")
+	}
+
+	return desc
+}
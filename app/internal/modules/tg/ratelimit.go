@@ -0,0 +1,72 @@
+package tg
+
+import "time"
+
+// https://core.telegram.org/bots/faq#my-bot-is-hitting-limits-how-do-i-avoid-this
+const (
+	globalRate  = 30.0        // messages per second to all the chats
+	chatRate    = 1.0         // messages per second to a single chat
+	groupRate   = 20.0 / 60.0 // messages per second to a group
+	globalBurst = 30
+	chatBurst   = 3
+)
+
+// tokenBucket allows rate events per second on average and up to burst events at once,
+// it is not safe for concurrent use
+type tokenBucket struct {
+	rate       float64
+	burst      float64
+	tokens     float64
+	last       time.Time
+	pausedTill time.Time // telegram asked to wait with retry_after
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// newChatBucket limits the groups stricter, their ids are negative
+func newChatBucket(chatId int) *tokenBucket {
+	if chatId < 0 {
+		return newTokenBucket(groupRate, chatBurst)
+	}
+	return newTokenBucket(chatRate, chatBurst)
+}
+
+// delay returns how long to wait for a token, zero if there is one already
+func (b *tokenBucket) delay(now time.Time) time.Duration {
+	if now.Before(b.pausedTill) {
+		return b.pausedTill.Sub(now)
+	}
+
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take spends a token, the caller must make sure there is one with delay
+func (b *tokenBucket) take(now time.Time) {
+	b.refill(now)
+	b.tokens--
+}
+
+func (b *tokenBucket) pause(till time.Time) {
+	if till.After(b.pausedTill) {
+		b.pausedTill = till
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// idle reports whether the bucket is full again and can be forgotten
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst && !now.Before(b.pausedTill)
+}
@@ -0,0 +1,65 @@
+package tg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type commandHandler func(ctx context.Context, worker Worker, args string)
+
+type command struct {
+	name        string // without the slash
+	args        string // shown in the help, e.g. [query_id]
+	description string
+	handler     commandHandler
+}
+
+// router dispatches the commands to the handlers they have been registered with,
+// the commands keep the order of registration in the help and the bot menu
+type router struct {
+	commands []*command
+	byName   map[string]*command
+}
+
+func newRouter() *router {
+	return &router{byName: make(map[string]*command)}
+}
+
+func (r *router) register(name, args, description string, handler commandHandler) {
+	cmd := &command{name: name, args: args, description: description, handler: handler}
+	r.commands = append(r.commands, cmd)
+	r.byName[name] = cmd
+}
+
+func (r *router) lookup(name string) (*command, bool) {
+	cmd, ok := r.byName[name]
+	return cmd, ok
+}
+
+// help lists the commands with their arguments
+func (r *router) help() string {
+	lines := make([]string, len(r.commands))
+	for i, cmd := range r.commands {
+		usage := "/" + cmd.name
+		if cmd.args != "" {
+			usage += " " + cmd.args
+		}
+		lines[i] = fmt.Sprintf("<b>%s</b> – %s", usage, cmd.description)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseCommand splits "/name@bot args", forUs is false if the command is addressed to another bot,
+// which happens in group chats, the suffix is not checked until the bot knows its username
+func parseCommand(text, username string) (name, args string, forUs bool) {
+	head := strings.TrimPrefix(text, "/")
+	if i := strings.IndexFunc(head, unicode.IsSpace); i >= 0 {
+		head, args = head[:i], head[i:]
+	}
+	name, bot, addressed := strings.Cut(head, "@")
+
+	forUs = !addressed || username == "" || strings.EqualFold(bot, username)
+	return strings.ToLower(name), strings.TrimSpace(args), forUs
+}
@@ -0,0 +1,117 @@
+package tg
+
+import (
+	"app/internal/storage"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// defaultSettings keep the hours the bot used to work before the settings were introduced
+func defaultSettings() storage.Settings {
+	return storage.Settings{Timezone: "Europe/Moscow", HoursFrom: 7, HoursTo: 22}
+}
+
+// isActive reports whether notifications may be delivered at the given moment
+func isActive(settings storage.Settings, loc *time.Location, now time.Time) bool {
+	now = now.In(loc)
+
+	if len(settings.Weekdays) > 0 && !slices.Contains(settings.Weekdays, now.Weekday()) {
+		return false
+	}
+
+	from, to, hour := settings.HoursFrom, settings.HoursTo, now.Hour()
+	switch {
+	case from == to || (from == 0 && to == 24):
+		return true
+	case from < to:
+		return hour >= from && hour < to
+	default: // the window spans midnight, e.g. 22-6
+		return hour >= from || hour < to
+	}
+}
+
+func parseHours(value string) (from, to int, err error) {
+	fromStr, toStr, ok := strings.Cut(strings.TrimSpace(value), "-")
+	if !ok {
+		return 0, 0, errors.New("hours should look like 9-21")
+	}
+
+	if from, err = strconv.Atoi(fromStr); err != nil || from < 0 || from > 23 {
+		return 0, 0, fmt.Errorf("invalid start hour %s", fromStr)
+	}
+	if to, err = strconv.Atoi(toStr); err != nil || to < 0 || to > 24 {
+		return 0, 0, fmt.Errorf("invalid end hour %s", toStr)
+	}
+
+	return from, to, nil
+}
+
+// parseWeekdays accepts "all", ranges like mon-fri and lists like mon,wed,fri
+func parseWeekdays(value string) ([]time.Weekday, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "all" || value == "" {
+		return nil, nil
+	}
+
+	days := make([]time.Weekday, 0, 7)
+	for _, part := range strings.Split(value, ",") {
+		fromStr, toStr, isRange := strings.Cut(strings.TrimSpace(part), "-")
+
+		from, ok := weekdays[fromStr]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %s", fromStr)
+		}
+		if !isRange {
+			days = appendWeekday(days, from)
+			continue
+		}
+
+		to, ok := weekdays[toStr]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %s", toStr)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days = appendWeekday(days, d)
+			if d == to {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+func describeSettings(settings storage.Settings) string {
+	days := "every day"
+	if len(settings.Weekdays) > 0 {
+		names := make([]string, len(settings.Weekdays))
+		for i, d := range settings.Weekdays {
+			names[i] = d.String()[:3]
+		}
+		days = strings.Join(names, ", ")
+	}
+
+	return fmt.Sprintf("notifications from %d:00 till %d:00 (%s), %s",
+		settings.HoursFrom, settings.HoursTo, settings.Timezone, days)
+}
+
+func appendWeekday(days []time.Weekday, day time.Weekday) []time.Weekday {
+	if slices.Contains(days, day) {
+		return days
+	}
+	return append(days, day)
+}
@@ -0,0 +1,63 @@
+package tg
+
+import (
+	"app/internal/lib/e"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+const (
+	headerSecretToken = "X-Telegram-Bot-Api-Secret-Token"
+	maxUpdateSize     = 1 << 20
+)
+
+// SetWebhook makes telegram deliver the updates to webhookUrl, signing the requests with secret
+func (c *Client) SetWebhook(ctx context.Context, webhookUrl, secret string) (err error) {
+	defer func() { err = e.WrapIfErr("couldn't set webhook", err) }()
+
+	query := url.Values{
+		"url":          []string{webhookUrl},
+		"secret_token": []string{secret},
+	}
+
+	return c.call(ctx, methodSetWebhook, query)
+}
+
+// DeleteWebhook switches telegram back to getUpdates, the pending updates are kept
+func (c *Client) DeleteWebhook(ctx context.Context) (err error) {
+	defer func() { err = e.WrapIfErr("couldn't delete webhook", err) }()
+
+	return c.call(ctx, methodDeleteWebhook, nil)
+}
+
+// WebhookHandler accepts the updates posted by telegram, the requests without the secret are rejected,
+// the updates are processed with ctx, just like the polled ones
+func (c *Client) WebhookHandler(ctx context.Context, secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.Header.Get(headerSecretToken)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			log.Printf("rejected webhook request from %s: wrong secret token\n", r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var update Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update); err != nil {
+			log.Println(e.WrapIfErr("couldn't decode webhook update", err).Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		c.ProcessUpdates(ctx, []Update{update})
+		w.WriteHeader(http.StatusOK)
+	})
+}
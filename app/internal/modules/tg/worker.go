@@ -3,109 +3,407 @@ package tg
 import (
 	"app/internal/lib/e"
 	"app/internal/modules/hh"
+	"app/internal/modules/source"
+	"app/internal/scheduler"
 	"app/internal/storage"
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	defaultSearchPeriod = time.Hour * 24
+	watermarkOverlap    = time.Minute * 10 // hh.ru may index vacancies with a delay
+	vacancyTTL          = time.Hour * 72
+	seenRefresh         = time.Hour * 24 // must be shorter than vacancyTTL
+	querySearchJobs     = 2              // searches of a single chat running at once
+
+	orderByPublicationTime = "publication_time"
+)
+
 type Worker interface {
-	Work()
-	DoSearch(Query)
+	Work(context.Context)
+	DoSearch(ctx context.Context, queryId int) error
 	HandleAddQuery(string) error
-	RemoveQuery(string) error
+	HandleAddFeed(string) error
+	AddQuery(Query) error
+	RemoveQuery(queryId int) error
 	Queries() []Query
 	ChatId() int
 	IsWorking() bool
 	StopWorking()
+	Settings() storage.Settings
+	SetTimezone(string) error
+	SetHours(string) error
+	SetWeekdays(string) error
+	SetInterval(string) error
+	Interval() time.Duration
+	QueryInterval(Query) time.Duration
+	Decide(action, key string) error
+	Flush()
 }
 
+// WorkingAgent keeps the state of a single chat, all the fields below mux are guarded by it
+// and the methods are safe to call from several goroutines
 type WorkingAgent struct {
-	chatId          int
-	isWorking       bool
-	stopWorking     chan bool
-	workingInterval time.Duration
-	queries         []Query
-	vacancies       map[string]time.Time
-	mux             *sync.RWMutex
-	storage         storage.Storage
-	tgClient        Telegramer
-	hhClient        hh.HeadHunterer
-}
-
-func NewWorkingAgent(chatId int, interval time.Duration, store storage.Storage, tgClient Telegramer, hhClient hh.HeadHunterer) *WorkingAgent {
+	chatId    int
+	intervals Intervals
+	mux       *sync.RWMutex
+	searchMux *sync.Mutex // serializes search cycles
+
+	isWorking  bool
+	ctx        context.Context // of the current work
+	cancel     context.CancelFunc
+	cleanedAt  time.Time
+	searchedAt map[string]time.Time // last scheduled search per query
+	queries    []Query
+	vacancies  map[string]time.Time
+	settings   storage.Settings
+	location   *time.Location
+	pending    []storage.Notification // held back during quiet hours
+	decisions  storage.Decisions
+
+	storage   storage.Storage
+	tgClient  Telegramer
+	searcher  source.Searcher
+	resolver  hh.Resolver
+	scheduler *scheduler.Scheduler
+}
+
+func NewWorkingAgent(chatId int, intervals Intervals, store storage.Storage, tgClient Telegramer, searcher source.Searcher, resolver hh.Resolver, sched *scheduler.Scheduler) *WorkingAgent {
 	w := &WorkingAgent{
-		chatId:          chatId,
-		stopWorking:     make(chan bool),
-		workingInterval: interval,
-		queries:         make([]Query, 0),
-		vacancies:       make(map[string]time.Time),
-		mux:             new(sync.RWMutex),
-		storage:         store,
-		tgClient:        tgClient,
-		hhClient:        hhClient,
+		chatId:     chatId,
+		intervals:  intervals,
+		mux:        new(sync.RWMutex),
+		searchMux:  new(sync.Mutex),
+		cleanedAt:  time.Now(),
+		searchedAt: make(map[string]time.Time),
+		queries:    make([]Query, 0),
+		vacancies:  make(map[string]time.Time),
+		storage:    store,
+		tgClient:   tgClient,
+		searcher:   searcher,
+		resolver:   resolver,
+		scheduler:  sched,
 	}
 	w.initQueries()
+	w.initVacancies()
+	w.initSettings()
+	w.initPending()
+	w.initDecisions()
 	return w
 }
 
-func (w *WorkingAgent) Work() {
+// Work hands the chat over to the scheduler, which runs the searches until StopWorking is called or ctx is cancelled,
+// calling Work on a working agent reschedules it
+func (w *WorkingAgent) Work(ctx context.Context) {
+	interval := w.jobInterval()
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.cancel != nil {
+		w.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w.ctx, w.cancel = ctx, cancel
 	w.isWorking = true
 
-	workTicker := time.NewTicker(w.workingInterval)
-	cleanTicker := time.NewTicker(time.Hour * 24)
-
-	for {
-		select {
-		case <-workTicker.C:
-			currHour := time.Now().Hour()
-			if currHour > 3 && currHour < 19 { // work only during the day, correct for GMT+3
-				for _, query := range w.queries {
-					go w.DoSearch(query)
-				}
+	w.scheduler.Add(w.jobId(), interval, func() { w.doScheduledWork(ctx) })
+	w.saveWorking(true)
+}
+
+// StopWorking unschedules the agent and interrupts the search in progress, it never blocks
+func (w *WorkingAgent) StopWorking() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.cancel != nil {
+		w.cancel()
+		w.ctx, w.cancel = nil, nil
+	}
+	w.isWorking = false
+
+	w.scheduler.Remove(w.jobId())
+	w.saveWorking(false)
+}
+
+// doScheduledWork runs only the queries whose interval has passed, the job itself runs
+// with the shortest of the query intervals
+func (w *WorkingAgent) doScheduledWork(ctx context.Context) {
+	now, tolerance := time.Now(), w.jobInterval()/2
+
+	w.doSearch(ctx, func(q Query) bool {
+		w.mux.RLock()
+		searchedAt := w.searchedAt[q.Key()]
+		w.mux.RUnlock()
+
+		return now.Sub(searchedAt) >= w.QueryInterval(q)-tolerance
+	})
+
+	w.mux.Lock()
+	clean := time.Since(w.cleanedAt) > time.Hour*24
+	if clean {
+		w.cleanedAt = time.Now()
+	}
+	w.mux.Unlock()
+
+	if clean {
+		w.cleanVacancies()
+	}
+}
+
+func (w *WorkingAgent) jobId() string {
+	return "chat/" + strconv.Itoa(w.chatId)
+}
+
+// DoSearch runs all the queries of the chat, or only the one with queryId if it isn't 0,
+// and sends a single message per new vacancy, even if several queries matched it
+func (w *WorkingAgent) DoSearch(ctx context.Context, queryId int) error {
+	if queryId == 0 {
+		w.doSearch(ctx, func(Query) bool { return true })
+		return nil
+	}
+
+	queries := w.Queries()
+	if queryId < 1 || queryId > len(queries) {
+		return errors.New("index out of range")
+	}
+
+	key := queries[queryId-1].Key()
+	w.doSearch(ctx, func(q Query) bool { return q.Key() == key })
+	return nil
+}
+
+func (w *WorkingAgent) doSearch(ctx context.Context, due func(Query) bool) {
+	// a manual /check may overlap with a scheduled search
+	w.searchMux.Lock()
+	defer w.searchMux.Unlock()
+
+	active := w.isActive()
+	if active {
+		w.flushPending(ctx)
+	}
+
+	queries := make([]numberedQuery, 0)
+	for i, q := range w.Queries() {
+		if due(q) {
+			queries = append(queries, numberedQuery{Query: q, number: i + 1})
+		}
+	}
+
+	if len(queries) == 0 {
+		return
+	}
+
+	results := make([][]source.Vacancy, len(queries))
+	watermarks := make([]time.Time, len(queries))
+	// every query may fetch many pages, so a chat with lots of queries runs only a few at once,
+	// the scheduler limits the number of the chats searching at the same time
+	wg, sem := new(sync.WaitGroup), make(chan struct{}, querySearchJobs)
+	for i, q := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i], watermarks[i] = w.search(ctx, q.Query)
+		}()
+	}
+	wg.Wait()
+
+	w.mux.Lock()
+	for _, q := range queries {
+		w.searchedAt[q.Key()] = time.Now()
+	}
+	w.mux.Unlock()
+
+	matches := make([]*match, 0)
+	byKey := make(map[string]*match)
+	for i, vacancies := range results {
+		for _, v := range vacancies {
+			m, ok := byKey[v.Key()]
+			if !ok {
+				m = &match{vacancy: v}
+				byKey[v.Key()] = m
+				matches = append(matches, m)
+			}
+			m.queries = append(m.queries, queries[i])
+		}
+	}
+
+	w.mux.RLock()
+	loc := w.location
+	w.mux.RUnlock()
+
+	// the vacancies that can't be delivered are queued rather than dropped, a vacancy is seen
+	// only once it has been delivered
+	sent, queued, dropped, refreshed := 0, 0, 0, 0
+	delivering := active
+	for _, m := range matches {
+		key := m.vacancy.Key()
+		if w.refreshSeen(key) {
+			refreshed++
+			continue
+		}
+		if w.isSeen(key) || w.isPending(key) || w.isDismissed(key, m.vacancy.EmployerKey()) {
+			continue
+		}
+
+		n := storage.Notification{Key: key, Employer: m.vacancy.EmployerKey(), Text: vacancyMessage(m.vacancy, m.queries, loc), QueuedAt: time.Now()}
+		if delivering && ctx.Err() == nil {
+			err := w.notify(ctx, n)
+			if err == nil {
+				w.markSeen(key)
+				sent++
+				continue
+			}
+
+			log.Println(e.WrapIfErr("couldn't deliver vacancy "+key+" to chat "+strconv.Itoa(w.chatId), err).Error())
+
+			// telegram rejected this one for good, queueing it would only block the others
+			if isPermanent(err) {
+				w.markSeen(key)
+				dropped++
+				continue
 			}
-		case <-cleanTicker.C:
-			go w.cleanVacancies()
 
-		case <-w.stopWorking:
-			w.isWorking = false
-			return
+			// telegram is most likely unavailable, the rest waits for the next search
+			delivering = false
+		}
+
+		w.mux.Lock()
+		w.pending = append(w.pending, n)
+		w.mux.Unlock()
+		queued++
+	}
+
+	if queued > 0 {
+		w.savePending()
+	}
+	if sent > 0 || dropped > 0 || refreshed > 0 {
+		w.saveVacancies()
+	}
+
+	// the watermarks move only now, a crash before this point makes the next search find
+	// the same vacancies again rather than lose the ones not yet delivered or queued
+	for i, q := range queries {
+		if !watermarks[i].IsZero() {
+			w.updateWatermark(q.Query, watermarks[i])
 		}
 	}
+
+	log.Printf("conducted search: sent %s%d%s and queued %s%d%s new vacancies for %s%d%s queries of chat %d\n", Magenta, sent, Reset, Magenta, queued, Reset, Green, len(queries), Reset, w.chatId)
 }
 
-func (w *WorkingAgent) DoSearch(q Query) {
-	vacancies, err := w.hhClient.GetVacancies(q.Area, q.Role, q.Text, q.Experience, 1)
+// search returns the vacancies published after the query watermark and the watermark to move to,
+// which is zero if it stays the same
+func (w *WorkingAgent) search(ctx context.Context, q Query) (fresh []source.Vacancy, watermark time.Time) {
+	now := time.Now()
+	since := now.Add(-defaultSearchPeriod)
+	if !q.Watermark.IsZero() {
+		since = q.Watermark.Add(-watermarkOverlap)
+	}
+	// the seen vacancies are forgotten after vacancyTTL, the ones published earlier would be sent again
+	if oldest := now.Add(-vacancyTTL); since.Before(oldest) {
+		since = oldest
+	}
+
+	// the results are capped by the pages, with the newest ones first the cap cuts off the oldest ones,
+	// so that the watermark can still move past the fetched ones
+	searched := q.Query
+	if searched.SourceName() == source.SourceHH {
+		searched.OrderBy = orderByPublicationTime
+	}
+
+	vacancies, found, err := w.searcher.Search(ctx, searched, since)
 	if err != nil {
 		log.Println(e.WrapIfErr(fmt.Sprintf("error getting vacancies for chat %d", w.chatId), err).Error())
+		return nil, time.Time{}
 	}
 
-	for _, v := range vacancies {
-		if _, ok := w.vacancies[v.ID]; !ok {
-			msg := fmt.Sprintf("Found new vacancy for <i>%s</i> with eperience <i>%s</i>:\nhttps://hh.ru/vacancy/%s", q.Text, q.Experience, v.ID)
-			w.tgClient.SendMessage(w.chatId, msg)
+	if found > len(vacancies) {
+		log.Printf("query %s%s%s is too broad: fetched %d of %d vacancies, the oldest ones are skipped\n", Green, q.Text, Reset, len(vacancies), found)
+	}
 
-			w.mux.Lock()
-			w.vacancies[v.ID] = time.Now()
-			w.mux.Unlock()
+	fresh = make([]source.Vacancy, 0, len(vacancies))
+	newest := q.Watermark
+	for _, v := range vacancies {
+		// vacancies from the same second as the watermark are kept, the seen ones are skipped later anyway,
+		// the undated ones can't be compared with the watermark, so they are kept too
+		if !v.PublishedAt.IsZero() && v.PublishedAt.Before(q.Watermark) {
+			continue
+		}
+		if v.PublishedAt.After(newest) {
+			newest = v.PublishedAt
 		}
+		fresh = append(fresh, v)
 	}
-	log.Printf("conducted search: found %s%d%s vacancies for %s%s%s %s%s%s\n", Magenta, len(vacancies), Reset, Green, q.Text, Reset, Yellow, q.Experience, Reset)
+
+	log.Printf("conducted search: found %s%d%s vacancies for %s%s%s %s%s%s\n", Magenta, len(fresh), Reset, Green, q.Text, Reset, Yellow, q.Experience, Reset)
+
+	// don't let a feed with skewed clocks push the watermark into the future
+	if newest.After(now) {
+		newest = now
+	}
+	if newest.After(q.Watermark) {
+		watermark = newest
+	}
+
+	return fresh, watermark
 }
 
 func (w *WorkingAgent) HandleAddQuery(query string) (err error) {
 	defer func() { err = e.WrapIfErr("couldn't handle query", err) }()
 
-	area, role, text, experience, err := w.parseAddQuery(query)
+	q, err := parseAddQuery(query)
 	if err != nil {
 		return err
 	}
 
-	file := storage.NewFile(w.chatId, fmt.Sprintf("%s %s %s %s", area, role, text, experience))
+	if err = w.resolveQuery(&q); err != nil {
+		return err
+	}
+
+	return w.addQuery(q)
+}
+
+func (w *WorkingAgent) HandleAddFeed(feed string) (err error) {
+	defer func() { err = e.WrapIfErr("couldn't handle feed", err) }()
+
+	q, err := parseAddFeed(feed)
+	if err != nil {
+		return err
+	}
+
+	return w.addQuery(q)
+}
+
+// AddQuery adds a query built by the /add wizard
+func (w *WorkingAgent) AddQuery(q Query) (err error) {
+	defer func() { err = e.WrapIfErr("couldn't add query", err) }()
+
+	if err = w.resolveQuery(&q); err != nil {
+		return err
+	}
+
+	return w.addQuery(q)
+}
+
+func (w *WorkingAgent) addQuery(q Query) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	file := w.queryFile(q)
 	exists, err := w.storage.IsExist(file)
 	if err != nil {
 		return err
@@ -118,20 +416,18 @@ func (w *WorkingAgent) HandleAddQuery(query string) (err error) {
 		return err
 	}
 
-	w.appendAddQuery(area, role, text, experience)
+	w.queries = append(w.queries, q)
 	return nil
 }
 
-func (w *WorkingAgent) RemoveQuery(regexMatch string) (err error) {
+// RemoveQuery removes the query by its number as shown by /queries
+func (w *WorkingAgent) RemoveQuery(queryId int) (err error) {
 	defer func() { err = e.WrapIfErr("couldn't remove query", err) }()
 
-	queryId := strings.Split(regexMatch, " ")[1]
-	id, err := strconv.Atoi(queryId)
-	if err != nil {
-		return err
-	}
+	id := queryId - 1
 
-	id -= 1
+	w.mux.Lock()
+	defer w.mux.Unlock()
 
 	if len(w.queries) == 0 {
 		return errors.New("queries list is empty")
@@ -140,74 +436,379 @@ func (w *WorkingAgent) RemoveQuery(regexMatch string) (err error) {
 	}
 
 	q := w.queries[id]
-	file := storage.NewFile(w.chatId, fmt.Sprintf("%s %s %s %s", q.Area, q.Role, q.Text, q.Experience))
+	file := w.queryFile(q)
 
 	if err = w.storage.Remove(file); err != nil {
 		return err
 	}
 
 	w.queries = append(w.queries[:id], w.queries[id+1:]...)
-	log.Println("query removed:", file.Query)
+	log.Println("query removed:", q.Key())
 
 	return nil
 }
 
 func (w *WorkingAgent) Queries() []Query {
-	return w.queries
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+
+	return append([]Query(nil), w.queries...)
 }
 
-func (w *WorkingAgent) ChatId() int {
-	return w.chatId
+func (w *WorkingAgent) Settings() storage.Settings {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+
+	return w.settings
 }
 
-func (w *WorkingAgent) IsWorking() bool {
-	return w.isWorking
+func (w *WorkingAgent) SetTimezone(name string) error {
+	name = strings.TrimSpace(name)
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" {
+		return fmt.Errorf("unknown timezone %q", name)
+	}
+
+	if err = w.updateSettings(func(s *storage.Settings) { s.Timezone = loc.String() }); err != nil {
+		return err
+	}
+
+	w.mux.Lock()
+	w.location = loc
+	w.mux.Unlock()
+
+	return nil
 }
 
-func (w *WorkingAgent) StopWorking() {
-	w.stopWorking <- true
+func (w *WorkingAgent) SetHours(hours string) error {
+	from, to, err := parseHours(hours)
+	if err != nil {
+		return err
+	}
+
+	return w.updateSettings(func(s *storage.Settings) {
+		s.HoursFrom, s.HoursTo = from, to
+	})
 }
 
-func (w *WorkingAgent) parseAddQuery(regexMatch string) (area string, role string, text string, exp string, err error) {
-	parts := strings.Split(regexMatch, " ")
-	if len(parts) != 5 {
-		return "", "", "", "", errors.New(fmt.Sprintf("should be exactly 5 parts, got: %d", len(parts)))
+func (w *WorkingAgent) SetWeekdays(days string) error {
+	weekdays, err := parseWeekdays(days)
+	if err != nil {
+		return err
 	}
 
-	area, role, text, exp = parts[1], parts[2], parts[3], parts[4]
+	return w.updateSettings(func(s *storage.Settings) {
+		s.Weekdays = weekdays
+	})
+}
+
+// SetInterval parses "[query_id] (duration|default)" and sets the interval of the query or the whole chat
+func (w *WorkingAgent) SetInterval(args string) (err error) {
+	defer func() { err = e.WrapIfErr("couldn't set interval", err) }()
 
-	switch exp {
-	case "0":
-		exp = "noExperience"
-	case "1-3":
-		exp = "between1And3"
-	case "3-6":
-		exp = "between3And6"
-	case "6":
-		exp = "moreThan6"
-	default:
-		exp = ""
+	queryId, interval, err := parseIntervalArgs(args)
+	if err != nil {
+		return err
 	}
 
-	return area, role, text, exp, nil
+	if interval > 0 {
+		if err = w.intervals.validate(interval); err != nil {
+			return err
+		}
+	}
+
+	if queryId == 0 {
+		err = w.updateSettings(func(s *storage.Settings) { s.Interval = interval })
+	} else {
+		err = w.setQueryInterval(queryId-1, interval)
+	}
+	if err != nil {
+		return err
+	}
+
+	if w.IsWorking() {
+		w.reschedule()
+	}
+
+	return nil
 }
 
-func (w *WorkingAgent) appendAddQuery(area, role, text, experience string) {
-	q := Query{Area: area, Role: role, Text: text, Experience: experience}
-	w.queries = append(w.queries, q)
+// reschedule applies the new interval to the job, keeping its context
+func (w *WorkingAgent) reschedule() {
+	interval := w.jobInterval()
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.isWorking {
+		ctx := w.ctx
+		w.scheduler.Add(w.jobId(), interval, func() { w.doScheduledWork(ctx) })
+	}
+}
+
+func (w *WorkingAgent) setQueryInterval(id int, interval time.Duration) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if id < 0 || id >= len(w.queries) {
+		return errors.New("index out of range")
+	}
+
+	q := w.queries[id]
+	q.Interval = interval
+	if err := w.storage.Save(w.queryFile(q)); err != nil {
+		return err
+	}
+
+	w.queries[id] = q
+	return nil
+}
+
+// Interval returns the search interval of the chat, queries may override it
+func (w *WorkingAgent) Interval() time.Duration {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+
+	if w.settings.Interval > 0 {
+		return w.intervals.clamp(w.settings.Interval)
+	}
+	return w.intervals.Default
+}
+
+func (w *WorkingAgent) QueryInterval(q Query) time.Duration {
+	if q.Interval > 0 {
+		return w.intervals.clamp(q.Interval)
+	}
+	return w.Interval()
+}
+
+// jobInterval is the shortest interval among the queries
+func (w *WorkingAgent) jobInterval() time.Duration {
+	interval := w.Interval()
+	for _, q := range w.Queries() {
+		interval = min(interval, w.QueryInterval(q))
+	}
+	return interval
+}
+
+func (w *WorkingAgent) updateSettings(update func(*storage.Settings)) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	settings := w.settings
+	update(&settings)
+
+	if err := w.storage.SaveSettings(w.chatId, settings); err != nil {
+		return err
+	}
+
+	w.settings = settings
+	return nil
+}
+
+func (w *WorkingAgent) isActive() bool {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+
+	return isActive(w.settings, w.location, time.Now())
+}
+
+// flushPending delivers the notifications held back during the quiet hours,
+// the ones left when ctx is cancelled are kept for the next time
+func (w *WorkingAgent) flushPending(ctx context.Context) {
+	w.mux.Lock()
+	pending := w.pending
+	w.pending = nil
+	w.mux.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	delivered := 0
+	for _, n := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		// the employer may have been hidden since the notification was queued
+		if !w.isDismissed(n.Key, n.Employer) {
+			err := w.notify(ctx, n)
+			if err != nil {
+				log.Println(e.WrapIfErr("couldn't deliver pending vacancy "+n.Key+" to chat "+strconv.Itoa(w.chatId), err).Error())
+			}
+			// a rejected notification is dropped, otherwise it would hold back all the ones after it
+			if err != nil && !isPermanent(err) {
+				break
+			}
+			w.markSeen(n.Key)
+		}
+		delivered++
+	}
+
+	if left := pending[delivered:]; len(left) > 0 {
+		w.mux.Lock()
+		w.pending = append(left, w.pending...)
+		w.mux.Unlock()
+	}
+
+	w.savePending()
+	if delivered > 0 {
+		w.saveVacancies()
+	}
+	log.Printf("delivered %s%d%s pending notifications for chat %d\n", Magenta, delivered, Reset, w.chatId)
+}
+
+func (w *WorkingAgent) notify(ctx context.Context, n storage.Notification) error {
+	return w.tgClient.SendMessageWithKeyboard(ctx, w.chatId, n.Text, vacancyKeyboard(n.Key, n.Employer))
+}
+
+func (w *WorkingAgent) markSeen(key string) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	w.vacancies[key] = time.Now()
+}
+
+func (w *WorkingAgent) isPending(key string) bool {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+
+	return slices.ContainsFunc(w.pending, func(n storage.Notification) bool { return n.Key == key })
+}
+
+// Decide records the reaction to a notification, the key is an employer key for actionHideEmployer
+// and a vacancy key otherwise
+func (w *WorkingAgent) Decide(action, key string) (err error) {
+	defer func() { err = e.WrapIfErr("couldn't save decision for chat "+strconv.Itoa(w.chatId), err) }()
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if action == actionHideEmployer {
+		w.decisions.Employers[key] = time.Now()
+	} else {
+		w.decisions.Vacancies[key] = action
+	}
+
+	return w.storage.SaveDecisions(w.chatId, w.decisions)
+}
+
+// isDismissed reports whether the chat doesn't want to hear about the vacancy anymore,
+// any decision counts since the chat has seen the vacancy then
+func (w *WorkingAgent) isDismissed(vacancyKey, employerKey string) bool {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+
+	if _, ok := w.decisions.Vacancies[vacancyKey]; ok {
+		return true
+	}
+	_, hidden := w.decisions.Employers[employerKey]
+	return employerKey != "" && hidden
+}
+
+// Flush saves the seen vacancies and pending notifications, the settings and queries are saved as they change
+func (w *WorkingAgent) Flush() {
+	w.savePending()
+	w.saveVacancies()
+}
+
+func (w *WorkingAgent) savePending() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if err := w.storage.SavePending(w.chatId, w.pending); err != nil {
+		log.Println(e.WrapIfErr("couldn't save pending notifications for chat "+strconv.Itoa(w.chatId), err).Error())
+	}
+}
+
+// refreshSeen keeps a seen vacancy the sources still return from expiring, the undated ones
+// pass any time filter, so they would be sent again once forgotten, it reports whether the time
+// has been updated, which is done no more often than once a day to spare the disk
+func (w *WorkingAgent) refreshSeen(key string) bool {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	seenAt, ok := w.vacancies[key]
+	if !ok || time.Since(seenAt) < seenRefresh {
+		return false
+	}
+
+	w.vacancies[key] = time.Now()
+	return true
+}
+
+func (w *WorkingAgent) isSeen(key string) bool {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+
+	_, ok := w.vacancies[key]
+	return ok
+}
+
+func (w *WorkingAgent) ChatId() int {
+	return w.chatId
+}
+
+func (w *WorkingAgent) IsWorking() bool {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+
+	return w.isWorking
+}
+
+// saveWorking remembers the state, so that the worker is resumed after a restart, the caller must hold the lock
+func (w *WorkingAgent) saveWorking(working bool) {
+	if w.settings.Working == working {
+		return
+	}
+
+	settings := w.settings
+	settings.Working = working
+	if err := w.storage.SaveSettings(w.chatId, settings); err != nil {
+		log.Println(e.WrapIfErr("couldn't save working state for chat "+strconv.Itoa(w.chatId), err).Error())
+		return
+	}
+	w.settings = settings
 }
 
 func (w *WorkingAgent) cleanVacancies() {
+	w.mux.Lock()
 	for id, createdAt := range w.vacancies {
-		if createdAt.Before(time.Now().Add(-time.Hour * 72)) {
-
-			w.mux.RLock()
+		if createdAt.Before(time.Now().Add(-vacancyTTL)) {
 			delete(w.vacancies, id)
-			w.mux.RUnlock()
-
 			log.Printf("deleted vacancy %s for chat %d\n", id, w.chatId)
 		}
 	}
+	w.mux.Unlock()
+
+	w.saveVacancies()
+}
+
+// saveVacancies holds the lock while saving, so that an older snapshot never overwrites a newer one
+func (w *WorkingAgent) saveVacancies() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if err := w.storage.SaveSeen(w.chatId, w.vacancies); err != nil {
+		log.Println(e.WrapIfErr("couldn't save vacancies for chat "+strconv.Itoa(w.chatId), err).Error())
+	}
+}
+
+// initVacancies restores the vacancies sent before the restart, so that they are not sent again
+func (w *WorkingAgent) initVacancies() {
+	seen, err := w.storage.ReadSeen(w.chatId)
+	if err != nil {
+		log.Println(e.WrapIfErr("couldn't read vacancies for chat "+strconv.Itoa(w.chatId), err).Error())
+		return
+	}
+
+	for id, sentAt := range seen {
+		if sentAt.After(time.Now().Add(-vacancyTTL)) {
+			w.vacancies[id] = sentAt
+		}
+	}
+
+	log.Printf("read %s%d%s seen vacancies for %s%d%s", Magenta, len(w.vacancies), Reset, Green, w.chatId, Reset)
 }
 
 func (w *WorkingAgent) initQueries() {
@@ -217,15 +818,128 @@ func (w *WorkingAgent) initQueries() {
 	}
 
 	for _, file := range files {
-		parts := strings.Split(file.Query, " ")
-		if len(parts) != 4 {
-			log.Println("expected 4 parts, got", file.Query)
+		if file.Query != "" {
+			query, err := w.migrateLegacyQuery(file)
+			if err != nil {
+				log.Println(e.WrapIfErr("couldn't migrate query "+file.Query, err).Error())
+				continue
+			}
+
+			w.queries = append(w.queries, query)
 			continue
 		}
 
-		query := Query{Area: parts[0], Role: parts[1], Text: parts[2], Experience: parts[3]}
-		w.queries = append(w.queries, query)
+		w.queries = append(w.queries, fileQuery(file))
 	}
 
 	log.Printf("read %s%d%s queries for %s%d%s", Magenta, len(w.queries), Reset, Green, w.chatId, Reset)
 }
+
+// resolveQuery replaces area and role names with their ids
+func (w *WorkingAgent) resolveQuery(q *Query) (err error) {
+	for i, area := range q.Areas {
+		if q.Areas[i], err = w.resolver.ResolveArea(area); err != nil {
+			return err
+		}
+	}
+
+	for i, role := range q.Roles {
+		if q.Roles[i], err = w.resolver.ResolveRole(role); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateWatermark persists the new watermark, so that the next searches start where this one stopped
+func (w *WorkingAgent) updateWatermark(q Query, watermark time.Time) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	for i := range w.queries {
+		if w.queries[i].Key() != q.Key() {
+			continue
+		}
+
+		w.queries[i].Watermark = watermark
+
+		if err := w.storage.Save(w.queryFile(w.queries[i])); err != nil {
+			log.Println(e.WrapIfErr("couldn't save watermark for chat "+strconv.Itoa(w.chatId), err).Error())
+		}
+		return
+	}
+}
+
+func (w *WorkingAgent) queryFile(q Query) *storage.File {
+	file := storage.NewFile(w.chatId, q.Key())
+	file.Params = storage.SearchParams(q.SearchParams)
+	file.Source = q.Source
+	file.FeedURL = q.FeedURL
+	file.Watermark = q.Watermark
+	file.Interval = q.Interval
+	return file
+}
+
+func fileQuery(file *storage.File) Query {
+	return Query{
+		Query:     source.Query{Source: file.Source, FeedURL: file.FeedURL, SearchParams: hh.SearchParams(file.Params)},
+		Watermark: file.Watermark,
+		Interval:  file.Interval,
+	}
+}
+
+// migrateLegacyQuery re-saves a query stored in the legacy format as search params
+func (w *WorkingAgent) migrateLegacyQuery(legacy *storage.File) (q Query, err error) {
+	if q, err = parseLegacyQuery(legacy.Query); err != nil {
+		return q, err
+	}
+
+	if err = w.storage.Save(w.queryFile(q)); err != nil {
+		return q, err
+	}
+
+	if err = w.storage.Remove(legacy); err != nil {
+		return q, err
+	}
+
+	log.Println("query migrated:", q.Key())
+
+	return q, nil
+}
+
+func (w *WorkingAgent) initSettings() {
+	w.settings, w.location = defaultSettings(), time.UTC
+
+	settings, err := w.storage.ReadSettings(w.chatId)
+	if err != nil {
+		log.Println(e.WrapIfErr("couldn't read settings for chat "+strconv.Itoa(w.chatId), err).Error())
+	}
+	if settings != nil {
+		w.settings = *settings
+	}
+
+	loc, err := time.LoadLocation(w.settings.Timezone)
+	if err != nil {
+		log.Println(e.WrapIfErr("couldn't load timezone for chat "+strconv.Itoa(w.chatId), err).Error())
+		return
+	}
+	w.location = loc
+}
+
+func (w *WorkingAgent) initDecisions() {
+	decisions, err := w.storage.ReadDecisions(w.chatId)
+	if err != nil {
+		log.Println(e.WrapIfErr("couldn't read decisions for chat "+strconv.Itoa(w.chatId), err).Error())
+	}
+	w.decisions = decisions
+}
+
+func (w *WorkingAgent) initPending() {
+	pending, err := w.storage.ReadPending(w.chatId)
+	if err != nil {
+		log.Println(e.WrapIfErr("couldn't read pending notifications for chat "+strconv.Itoa(w.chatId), err).Error())
+		return
+	}
+	w.pending = pending
+}
@@ -0,0 +1,460 @@
+package tg
+
+import (
+	"app/internal/modules/hh"
+	"app/internal/modules/source"
+	"app/internal/scheduler"
+	"app/internal/storage"
+	"context"
+	"errors"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testChatId = 42
+
+var testIntervals = Intervals{Default: time.Second, Min: time.Millisecond * 100, Max: time.Hour}
+
+// fakeStorage keeps everything in memory, the values are copied on the way in and out,
+// so that the test doesn't share them with the agent
+type fakeStorage struct {
+	mux       *sync.Mutex
+	files     map[string]storage.File
+	seen      map[string]time.Time
+	settings  storage.Settings
+	pending   []storage.Notification
+	decisions storage.Decisions
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		mux:   new(sync.Mutex),
+		files: make(map[string]storage.File),
+		seen:  make(map[string]time.Time),
+		// the notifications are delivered around the clock
+		settings: storage.Settings{Timezone: "UTC"},
+		decisions: storage.Decisions{
+			Vacancies: make(map[string]string),
+			Employers: make(map[string]time.Time),
+		},
+	}
+}
+
+func (s *fakeStorage) Save(f *storage.File) error {
+	hash, err := f.Hash()
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.files[hash] = *f
+	return nil
+}
+
+func (s *fakeStorage) Remove(f *storage.File) error {
+	hash, err := f.Hash()
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.files[hash]; !ok {
+		return errors.New("file doesn't exist")
+	}
+	delete(s.files, hash)
+	return nil
+}
+
+func (s *fakeStorage) ReadAll(chatId int) ([]*storage.File, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	files := make([]*storage.File, 0, len(s.files))
+	for _, f := range s.files {
+		if f.ChatID == chatId {
+			files = append(files, &f)
+		}
+	}
+	return files, nil
+}
+
+func (s *fakeStorage) IsExist(f *storage.File) (bool, error) {
+	hash, err := f.Hash()
+	if err != nil {
+		return false, err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	_, ok := s.files[hash]
+	return ok, nil
+}
+
+func (s *fakeStorage) SaveSeen(_ int, seen map[string]time.Time) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.seen = maps.Clone(seen)
+	return nil
+}
+
+func (s *fakeStorage) ReadSeen(int) (map[string]time.Time, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return maps.Clone(s.seen), nil
+}
+
+func (s *fakeStorage) SaveSettings(_ int, settings storage.Settings) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.settings = settings
+	s.settings.Weekdays = slices.Clone(settings.Weekdays)
+	return nil
+}
+
+func (s *fakeStorage) ReadSettings(int) (*storage.Settings, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	settings := s.settings
+	settings.Weekdays = slices.Clone(s.settings.Weekdays)
+	return &settings, nil
+}
+
+func (s *fakeStorage) SavePending(_ int, pending []storage.Notification) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.pending = slices.Clone(pending)
+	return nil
+}
+
+func (s *fakeStorage) ReadPending(int) ([]storage.Notification, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return slices.Clone(s.pending), nil
+}
+
+func (s *fakeStorage) SaveDecisions(_ int, decisions storage.Decisions) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.decisions = storage.Decisions{Vacancies: maps.Clone(decisions.Vacancies), Employers: maps.Clone(decisions.Employers)}
+	return nil
+}
+
+func (s *fakeStorage) ReadDecisions(int) (storage.Decisions, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return storage.Decisions{Vacancies: maps.Clone(s.decisions.Vacancies), Employers: maps.Clone(s.decisions.Employers)}, nil
+}
+
+func (s *fakeStorage) ChatIDs() ([]int, error)                    { return []int{testChatId}, nil }
+func (s *fakeStorage) SaveOffset(storage.Offset) error            { return nil }
+func (s *fakeStorage) ReadOffset() (*storage.Offset, error)       { return nil, nil }
+func (s *fakeStorage) SaveOutbox([]storage.OutgoingMessage) error { return nil }
+func (s *fakeStorage) ReadOutbox() ([]storage.OutgoingMessage, error) {
+	return nil, nil
+}
+
+// fakeTelegram counts the notifications per vacancy
+type fakeTelegram struct {
+	mux  *sync.Mutex
+	sent map[string]int
+}
+
+func newFakeTelegram() *fakeTelegram {
+	return &fakeTelegram{mux: new(sync.Mutex), sent: make(map[string]int)}
+}
+
+func (t *fakeTelegram) SendMessageWithKeyboard(ctx context.Context, _ int, _ string, keyboard InlineKeyboardMarkup) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, key, err := parseCallbackData(keyboard.InlineKeyboard[0][0].CallbackData)
+	if err != nil {
+		return err
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.sent[key]++
+	return nil
+}
+
+func (t *fakeTelegram) sentTimes() map[string]int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	return maps.Clone(t.sent)
+}
+
+func (t *fakeTelegram) GetUpdates(context.Context) ([]Update, error)        { return nil, nil }
+func (t *fakeTelegram) ProcessUpdates(context.Context, []Update)            {}
+func (t *fakeTelegram) ResumeWorkers(context.Context)                       {}
+func (t *fakeTelegram) RunOutbox(context.Context)                           {}
+func (t *fakeTelegram) SendMessage(context.Context, int, string) error      { return nil }
+func (t *fakeTelegram) PublishCommands(context.Context) error               { return nil }
+func (t *fakeTelegram) SetWebhook(context.Context, string, string) error    { return nil }
+func (t *fakeTelegram) DeleteWebhook(context.Context) error                 { return nil }
+func (t *fakeTelegram) WebhookHandler(context.Context, string) http.Handler { return nil }
+func (t *fakeTelegram) Flush()                                              {}
+
+// fakeSearcher returns a few vacancies of its own for every query and one shared by all of them
+type fakeSearcher struct {
+	publishedAt time.Time
+}
+
+func (s fakeSearcher) Search(ctx context.Context, q source.Query, _ time.Time) ([]source.Vacancy, int, error) {
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	case <-time.After(time.Millisecond):
+	}
+
+	vacancies := []source.Vacancy{s.vacancy("shared")}
+	for i := range 3 {
+		vacancies = append(vacancies, s.vacancy(q.Text+"-"+strconv.Itoa(i)))
+	}
+	return vacancies, len(vacancies), nil
+}
+
+func (s fakeSearcher) vacancy(id string) source.Vacancy {
+	return source.Vacancy{ID: id, Source: source.SourceHH, Title: id, EmployerID: "e-" + id, URL: "https://hh.ru/vacancy/" + id, PublishedAt: s.publishedAt}
+}
+
+type fakeResolver struct{}
+
+func (fakeResolver) ResolveArea(nameOrId string) (string, error) { return nameOrId, nil }
+func (fakeResolver) ResolveRole(nameOrId string) (string, error) { return nameOrId, nil }
+func (fakeResolver) AreaName(id string) string                   { return id }
+func (fakeResolver) RoleName(id string) string                   { return id }
+func (fakeResolver) DictionaryName(_, id string) string          { return id }
+
+var _ hh.Resolver = fakeResolver{}
+
+func newTestAgent(t *testing.T, tg *fakeTelegram) (*WorkingAgent, *scheduler.Scheduler) {
+	t.Helper()
+
+	sched := scheduler.NewScheduler(scheduler.RealClock{}, rand.New(rand.NewPCG(1, 2)), 2, 0.1)
+	w := NewWorkingAgent(testChatId, testIntervals, newFakeStorage(), tg, fakeSearcher{publishedAt: time.Now().Add(-time.Minute)}, fakeResolver{}, sched)
+
+	for _, text := range []string{"golang", "python", "rust"} {
+		if err := w.AddQuery(testQuery(text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return w, sched
+}
+
+func testQuery(text string) Query {
+	return Query{Query: source.Query{SearchParams: hh.SearchParams{Areas: []string{"1"}, Roles: []string{"96"}, Text: text}}}
+}
+
+func TestStopWorkingWithoutWork(t *testing.T) {
+	w, _ := newTestAgent(t, newFakeTelegram())
+
+	stopped := make(chan struct{})
+	go func() {
+		w.StopWorking()
+		w.StopWorking()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second * 5):
+		t.Fatal("StopWorking blocked on an agent that never worked")
+	}
+
+	if w.IsWorking() {
+		t.Error("agent is working after StopWorking")
+	}
+}
+
+func TestWorkingAgentConcurrentUse(t *testing.T) {
+	tg := newFakeTelegram()
+	w, sched := newTestAgent(t, tg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the scheduler runs the agent's own searches meanwhile
+	done := make(chan struct{})
+	schedulerDone := make(chan struct{})
+	go func() {
+		sched.Run(done)
+		close(schedulerDone)
+	}()
+
+	const rounds = 30
+	calls := []func(i int){
+		func(int) { w.Work(ctx) },
+		func(int) { w.StopWorking() },
+		func(i int) { _ = w.DoSearch(ctx, i%4) },
+		func(int) { _ = w.DoSearch(ctx, 0) },
+		func(int) {
+			queries := w.Queries()
+			if err := w.RemoveQuery(len(queries)); err == nil {
+				_ = w.AddQuery(queries[len(queries)-1])
+			}
+		},
+		func(i int) { _ = w.SetInterval([]string{"2s", "1 3s", "default", "2 default"}[i%4]) },
+		func(i int) {
+			_ = w.Decide([]string{actionNotInterested, actionSave, actionApplied}[i%3], "hh:golang-"+strconv.Itoa(i%3))
+		},
+		func(int) { _ = w.Decide(actionHideEmployer, "hh:e-python-0") },
+		func(int) { _, _, _ = w.IsWorking(), w.Interval(), w.Settings() },
+		func(int) { w.Flush() },
+	}
+
+	wg := new(sync.WaitGroup)
+	for _, call := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range rounds {
+				call(i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	w.StopWorking()
+	close(done)
+	<-schedulerDone
+
+	for key, n := range tg.sentTimes() {
+		if n > 1 {
+			t.Errorf("vacancy %s was sent %d times", key, n)
+		}
+	}
+}
+
+func TestConcurrentSearchesNotifyOnce(t *testing.T) {
+	tg := newFakeTelegram()
+	w, _ := newTestAgent(t, tg)
+
+	wg := new(sync.WaitGroup)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = w.DoSearch(context.Background(), 0)
+		}()
+	}
+	wg.Wait()
+
+	sent := tg.sentTimes()
+	// the shared vacancy and 3 of every query
+	if len(sent) != 10 {
+		t.Errorf("%d vacancies were sent, want 10", len(sent))
+	}
+	for key, n := range sent {
+		if n != 1 {
+			t.Errorf("vacancy %s was sent %d times, want 1", key, n)
+		}
+	}
+}
+
+func TestStopWorkingInterruptsScheduledWork(t *testing.T) {
+	w, _ := newTestAgent(t, newFakeTelegram())
+
+	w.Work(context.Background())
+
+	w.mux.RLock()
+	ctx := w.ctx
+	w.mux.RUnlock()
+
+	w.StopWorking()
+
+	select {
+	case <-ctx.Done():
+	default:
+		t.Error("the context of the work is still alive after StopWorking")
+	}
+	if w.IsWorking() {
+		t.Error("agent is working after StopWorking")
+	}
+}
+
+// slowSearcher records how many searches run at once
+type slowSearcher struct {
+	running, peak atomic.Int32
+}
+
+func (s *slowSearcher) Search(context.Context, source.Query, time.Time) ([]source.Vacancy, int, error) {
+	n := s.running.Add(1)
+	defer s.running.Add(-1)
+
+	for p := s.peak.Load(); n > p && !s.peak.CompareAndSwap(p, n); p = s.peak.Load() {
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	return nil, 0, nil
+}
+
+func TestChatSearchesAreLimited(t *testing.T) {
+	searcher := new(slowSearcher)
+	sched := scheduler.NewScheduler(scheduler.RealClock{}, rand.New(rand.NewPCG(1, 2)), 1, 0)
+	w := NewWorkingAgent(testChatId, testIntervals, newFakeStorage(), newFakeTelegram(), searcher, fakeResolver{}, sched)
+
+	for i := range 8 {
+		if err := w.AddQuery(testQuery("query" + strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.DoSearch(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := searcher.peak.Load(); p > querySearchJobs {
+		t.Errorf("%d searches of the chat ran at once, want at most %d", p, querySearchJobs)
+	}
+}
+
+func TestSeenVacanciesStillReturnedDontExpire(t *testing.T) {
+	store, tg := newFakeStorage(), newFakeTelegram()
+	store.seen["hh:shared"] = time.Now().Add(-vacancyTTL + time.Hour)
+
+	sched := scheduler.NewScheduler(scheduler.RealClock{}, rand.New(rand.NewPCG(1, 2)), 1, 0)
+	w := NewWorkingAgent(testChatId, testIntervals, store, tg, fakeSearcher{}, fakeResolver{}, sched)
+	if err := w.AddQuery(testQuery("golang")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.DoSearch(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the undated vacancy has been seen long ago, but it's still in the results
+	if n := tg.sentTimes()["hh:shared"]; n != 0 {
+		t.Errorf("seen vacancy was sent %d times", n)
+	}
+
+	w.cleanVacancies()
+	seen, _ := store.ReadSeen(testChatId)
+	if seenAt, ok := seen["hh:shared"]; !ok || time.Since(seenAt) > time.Minute {
+		t.Errorf("seen vacancy still in the results wasn't refreshed: %v, %v", seenAt, ok)
+	}
+}
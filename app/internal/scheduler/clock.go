@@ -0,0 +1,19 @@
+package scheduler
+
+import "time"
+
+// Clock is the source of time for the scheduler, tests may replace it with a manual one
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
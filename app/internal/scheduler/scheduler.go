@@ -0,0 +1,143 @@
+package scheduler
+
+import (
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const resolution = time.Second // how often the scheduler looks for due jobs
+
+// Scheduler runs periodic jobs from a single loop: first runs are spread over the job interval,
+// every next run is shifted by a random jitter and no more than concurrency jobs run at once
+type Scheduler struct {
+	clock  Clock
+	random *rand.Rand // used under mux only, since it isn't safe for concurrent use
+	jitter float64    // fraction of the interval
+	sem    chan struct{}
+	mux    *sync.Mutex
+	jobs   map[string]*job
+	wg     *sync.WaitGroup
+}
+
+type job struct {
+	interval time.Duration
+	next     time.Time
+	running  bool
+	run      func()
+}
+
+// NewScheduler takes the source of randomness for the spread and the jitter, so that the tests
+// can make them deterministic
+func NewScheduler(clock Clock, random *rand.Rand, concurrency int, jitter float64) *Scheduler {
+	return &Scheduler{
+		clock:  clock,
+		random: random,
+		jitter: jitter,
+		sem:    make(chan struct{}, max(concurrency, 1)),
+		mux:    new(sync.Mutex),
+		jobs:   make(map[string]*job),
+		wg:     new(sync.WaitGroup),
+	}
+}
+
+// Add schedules run every interval, replacing the job with the same id if there is one
+func (s *Scheduler) Add(id string, interval time.Duration, run func()) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.jobs[id] = &job{
+		interval: interval,
+		next:     s.clock.Now().Add(s.randDuration(interval)),
+		run:      run,
+	}
+}
+
+func (s *Scheduler) Remove(id string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	delete(s.jobs, id)
+}
+
+// Run dispatches due jobs until done is closed and waits for the running ones to finish
+func (s *Scheduler) Run(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			s.wg.Wait()
+			return
+		case <-s.clock.After(resolution):
+			s.dispatch(done)
+		}
+	}
+}
+
+// dispatch starts the due jobs, the ones still waiting for their turn when done is closed are skipped
+func (s *Scheduler) dispatch(done <-chan struct{}) {
+	now := s.clock.Now()
+
+	s.mux.Lock()
+	due := make([]*job, 0)
+	for _, j := range s.jobs {
+		// a job that is still running skips its turn instead of piling up
+		if j.running || j.next.After(now) {
+			continue
+		}
+
+		j.running = true
+		j.next = now.Add(j.interval + s.jitterFor(j.interval))
+		due = append(due, j)
+	}
+	s.mux.Unlock()
+
+	if len(due) > 0 {
+		log.Printf("dispatching %d jobs\n", len(due))
+	}
+
+	for _, j := range due {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mux.Lock()
+				j.running = false
+				s.mux.Unlock()
+			}()
+
+			select {
+			case <-done:
+				return
+			case s.sem <- struct{}{}:
+			}
+			defer func() { <-s.sem }()
+
+			// both may have been ready, the shutdown wins
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			j.run()
+		}()
+	}
+}
+
+// jitterFor returns a random shift within ±jitter of the interval, the caller must hold the lock
+func (s *Scheduler) jitterFor(interval time.Duration) time.Duration {
+	spread := time.Duration(float64(interval) * s.jitter)
+	if spread <= 0 {
+		return 0
+	}
+	return s.randDuration(2*spread+1) - spread
+}
+
+// randDuration returns a random duration in [0, d), the caller must hold the lock
+func (s *Scheduler) randDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(s.random.Int64N(int64(d)))
+}
@@ -0,0 +1,269 @@
+package scheduler
+
+import (
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// manualClock only moves when the test advances it, After never fires, so the tests dispatch by hand
+type manualClock struct {
+	mux *sync.Mutex
+	now time.Time
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{mux: new(sync.Mutex), now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.now
+}
+
+func (c *manualClock) After(time.Duration) <-chan time.Time {
+	return make(chan time.Time)
+}
+
+func (c *manualClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func newTestScheduler(clock Clock, concurrency int, jitter float64) *Scheduler {
+	return NewScheduler(clock, rand.New(rand.NewPCG(1, 2)), concurrency, jitter)
+}
+
+func TestAddSpreadsFirstRuns(t *testing.T) {
+	clock := newManualClock()
+	s := newTestScheduler(clock, 1, 0)
+
+	const interval = time.Minute * 10
+	start := clock.Now()
+	distinct := make(map[time.Time]bool)
+
+	for i := range 20 {
+		s.Add(string(rune('a'+i)), interval, func() {})
+	}
+
+	for id, j := range s.jobs {
+		if j.next.Before(start) || !j.next.Before(start.Add(interval)) {
+			t.Errorf("job %s: first run at %v, want within [%v, %v)", id, j.next, start, start.Add(interval))
+		}
+		distinct[j.next] = true
+	}
+
+	if len(distinct) < 2 {
+		t.Errorf("first runs aren't spread: %d distinct times for 20 jobs", len(distinct))
+	}
+}
+
+func TestAddIsDeterministicWithSameSource(t *testing.T) {
+	first := newTestScheduler(newManualClock(), 1, 0.1)
+	second := newTestScheduler(newManualClock(), 1, 0.1)
+
+	first.Add("job", time.Hour, func() {})
+	second.Add("job", time.Hour, func() {})
+
+	if a, b := first.jobs["job"].next, second.jobs["job"].next; !a.Equal(b) {
+		t.Errorf("same source gave different first runs: %v and %v", a, b)
+	}
+}
+
+func TestJitterBounds(t *testing.T) {
+	clock := newManualClock()
+	s := newTestScheduler(clock, 1, 0.1)
+
+	const interval = time.Minute * 10
+	spread := time.Duration(float64(interval) * 0.1)
+
+	done := make(chan struct{}, 1)
+	s.Add("job", interval, func() { done <- struct{}{} })
+
+	below, above := false, false
+	for range 200 {
+		clock.Advance(s.jobs["job"].next.Sub(clock.Now()))
+		now := clock.Now()
+
+		s.dispatch(nil)
+		<-done
+		s.wg.Wait()
+
+		s.mux.Lock()
+		shift := s.jobs["job"].next.Sub(now) - interval
+		s.mux.Unlock()
+
+		if shift < -spread || shift > spread {
+			t.Fatalf("jitter %v is out of ±%v", shift, spread)
+		}
+		below = below || shift < 0
+		above = above || shift > 0
+	}
+
+	if !below || !above {
+		t.Errorf("jitter is one-sided: below %v, above %v", below, above)
+	}
+}
+
+func TestRunningJobSkipsItsTurn(t *testing.T) {
+	clock := newManualClock()
+	s := newTestScheduler(clock, 1, 0)
+
+	const interval = time.Minute
+	var runs atomic.Int32
+	release := make(chan struct{})
+	s.Add("job", interval, func() {
+		runs.Add(1)
+		<-release
+	})
+
+	clock.Advance(interval)
+	s.dispatch(nil)
+	waitFor(t, func() bool { return runs.Load() == 1 })
+
+	// the job is overdue again, but it's still running
+	clock.Advance(interval * 3)
+	s.dispatch(nil)
+
+	close(release)
+	s.wg.Wait()
+
+	if n := runs.Load(); n != 1 {
+		t.Fatalf("job ran %d times while it was running, want 1", n)
+	}
+
+	clock.Advance(interval)
+	s.dispatch(nil)
+	s.wg.Wait()
+
+	if n := runs.Load(); n != 2 {
+		t.Errorf("job ran %d times after it had finished, want 2", n)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	clock := newManualClock()
+	const concurrency = 2
+	s := newTestScheduler(clock, concurrency, 0)
+
+	var running, peak, started atomic.Int32
+	release := make(chan struct{})
+	for i := range 6 {
+		s.Add(string(rune('a'+i)), time.Minute, func() {
+			started.Add(1)
+			n := running.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			<-release
+			running.Add(-1)
+		})
+	}
+
+	clock.Advance(time.Minute)
+	s.dispatch(nil)
+
+	waitFor(t, func() bool { return started.Load() == concurrency })
+	time.Sleep(time.Millisecond * 50) // give the others a chance to break the limit
+	if n := started.Load(); n != concurrency {
+		t.Fatalf("%d jobs started at once, want %d", n, concurrency)
+	}
+
+	close(release)
+	s.wg.Wait()
+
+	if n := started.Load(); n != 6 {
+		t.Errorf("%d jobs ran, want 6", n)
+	}
+	if p := peak.Load(); p > concurrency {
+		t.Errorf("%d jobs ran at once, want at most %d", p, concurrency)
+	}
+}
+
+func TestRunWaitsForRunningJobs(t *testing.T) {
+	clock := newManualClock()
+	s := newTestScheduler(clock, 1, 0)
+
+	finished := make(chan struct{})
+	release := make(chan struct{})
+	s.Add("job", time.Minute, func() {
+		<-release
+		close(finished)
+	})
+
+	clock.Advance(time.Minute)
+	s.dispatch(nil)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(done)
+		close(stopped)
+	}()
+	close(done)
+
+	select {
+	case <-stopped:
+		t.Fatal("Run returned before the running job finished")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	close(release)
+	<-stopped
+
+	select {
+	case <-finished:
+	default:
+		t.Error("Run returned, but the job didn't finish")
+	}
+}
+
+func TestWaitingJobsAreSkippedOnShutdown(t *testing.T) {
+	clock := newManualClock()
+	s := newTestScheduler(clock, 1, 0)
+
+	var started atomic.Int32
+	release := make(chan struct{})
+	for i := range 3 {
+		s.Add(string(rune('a'+i)), time.Minute, func() {
+			started.Add(1)
+			<-release
+		})
+	}
+
+	done := make(chan struct{})
+	clock.Advance(time.Minute)
+	s.dispatch(done)
+	waitFor(t, func() bool { return started.Load() == 1 })
+
+	// the other two are waiting for the first one to free the slot
+	close(done)
+	close(release)
+	s.wg.Wait()
+
+	if n := started.Load(); n != 1 {
+		t.Errorf("%d jobs started, want only the one started before the shutdown", n)
+	}
+	for id, j := range s.jobs {
+		if j.running {
+			t.Errorf("skipped job %s is still marked as running", id)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition wasn't met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
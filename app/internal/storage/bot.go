@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"app/internal/lib/e"
+	"path/filepath"
+	"time"
+)
+
+// bot state is kept apart from the chats, ChatIDs skips the directory since its name isn't a number
+const botDir = "bot"
+
+const (
+	fileOffset = "offset"
+	fileOutbox = "outbox"
+)
+
+// Offset is the position of the bot in the telegram updates
+type Offset struct {
+	Confirmed int   // the updates before it won't be delivered again
+	Processed []int // the updates handled after Confirmed, which may be delivered again
+}
+
+func (s *QueriesStorage) SaveOffset(offset Offset) error {
+	return e.WrapIfErr("couldn't save offset", writeGob(s.botPath(fileOffset), offset))
+}
+
+// ReadOffset returns nil if the offset has never been saved
+func (s *QueriesStorage) ReadOffset() (offset *Offset, err error) {
+	if err = readGob(s.botPath(fileOffset), &offset); err != nil {
+		return nil, e.WrapIfErr("couldn't read offset", err)
+	}
+	return offset, nil
+}
+
+// OutgoingMessage is a message waiting to be sent to telegram
+type OutgoingMessage struct {
+	ChatID   int
+	Text     string
+	Markup   string // encoded reply markup, empty to keep the current one
+	QueuedAt time.Time
+	Attempts int
+}
+
+func (s *QueriesStorage) SaveOutbox(messages []OutgoingMessage) error {
+	return e.WrapIfErr("couldn't save outbox", writeGob(s.botPath(fileOutbox), messages))
+}
+
+func (s *QueriesStorage) ReadOutbox() (messages []OutgoingMessage, err error) {
+	if err = readGob(s.botPath(fileOutbox), &messages); err != nil {
+		return nil, e.WrapIfErr("couldn't read outbox", err)
+	}
+	return messages, nil
+}
+
+func (s *QueriesStorage) botPath(name string) string {
+	return filepath.Join(s.basPath, botDir, name)
+}
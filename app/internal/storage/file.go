@@ -6,15 +6,39 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"time"
 )
 
 type File struct {
-	ChatID int
-	Query  string
+	ChatID  int
+	Query   string // legacy "area role text experience" format, only set for files saved by older versions
+	Key     string // canonical key of the query, the file name is derived from it
+	Params  SearchParams
+	Source  string
+	FeedURL string
+
+	Watermark time.Time
+	Interval  time.Duration
+}
+
+// SearchParams keeps the fields of hh.SearchParams, so that the files saved before stay readable
+type SearchParams struct {
+	Areas          []string
+	Roles          []string
+	Text           string
+	Experience     string
+	Salary         int
+	OnlyWithSalary bool
+	Currency       string
+	Schedules      []string
+	Employments    []string
+	SearchFields   []string
+	OrderBy        string
+	Labels         []string
 }
 
-func NewFile(chatID int, query string) *File {
-	return &File{chatID, query}
+func NewFile(chatID int, key string) *File {
+	return &File{ChatID: chatID, Key: key}
 }
 
 func (f *File) Hash() (hash string, err error) {
@@ -25,9 +49,16 @@ func (f *File) Hash() (hash string, err error) {
 		return "", err
 	}
 
-	if _, err = io.WriteString(h, f.Query); err != nil {
+	if _, err = io.WriteString(h, f.key()); err != nil {
 		return "", err
 	}
 
 	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
+
+func (f *File) key() string {
+	if f.Query != "" {
+		return f.Query
+	}
+	return f.Key
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"app/internal/lib/e"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// chat state is kept in a subdirectory, so that ReadAll doesn't mistake it for queries
+const stateDir = "state"
+
+const (
+	fileSeen      = "seen"
+	fileSettings  = "settings"
+	filePending   = "pending"
+	fileDecisions = "decisions"
+)
+
+type Settings struct {
+	Timezone  string         // IANA name, e.g. Europe/Moscow
+	HoursFrom int            // notifications are delivered from HoursFrom
+	HoursTo   int            // till HoursTo, exclusive
+	Weekdays  []time.Weekday // every day if empty
+	Interval  time.Duration  // the default search interval if zero
+	Working   bool           // whether the worker has to be resumed after a restart
+}
+
+// Notification is a message held back until the chat's active hours
+type Notification struct {
+	Key      string // vacancy key
+	Employer string // employer key, empty if the source doesn't identify employers
+	Text     string
+	QueuedAt time.Time
+}
+
+// Decisions are the reactions of the chat to the notifications
+type Decisions struct {
+	Vacancies map[string]string    // vacancy key -> decision
+	Employers map[string]time.Time // hidden employers by key
+}
+
+func (s *QueriesStorage) SaveSeen(chatId int, seen map[string]time.Time) error {
+	return e.WrapIfErr("couldn't save seen vacancies", s.saveState(chatId, fileSeen, seen))
+}
+
+// ReadSeen returns an empty set if nothing has been saved for the chat yet
+func (s *QueriesStorage) ReadSeen(chatId int) (map[string]time.Time, error) {
+	seen := make(map[string]time.Time)
+	if err := s.readState(chatId, fileSeen, &seen); err != nil {
+		return nil, e.WrapIfErr("couldn't read seen vacancies", err)
+	}
+	return seen, nil
+}
+
+func (s *QueriesStorage) SaveSettings(chatId int, settings Settings) error {
+	return e.WrapIfErr("couldn't save settings", s.saveState(chatId, fileSettings, settings))
+}
+
+// ReadSettings returns nil if the chat has never changed its settings
+func (s *QueriesStorage) ReadSettings(chatId int) (settings *Settings, err error) {
+	if err = s.readState(chatId, fileSettings, &settings); err != nil {
+		return nil, e.WrapIfErr("couldn't read settings", err)
+	}
+	return settings, nil
+}
+
+func (s *QueriesStorage) SavePending(chatId int, pending []Notification) error {
+	return e.WrapIfErr("couldn't save pending notifications", s.saveState(chatId, filePending, pending))
+}
+
+func (s *QueriesStorage) ReadPending(chatId int) (pending []Notification, err error) {
+	if err = s.readState(chatId, filePending, &pending); err != nil {
+		return nil, e.WrapIfErr("couldn't read pending notifications", err)
+	}
+	return pending, nil
+}
+
+func (s *QueriesStorage) SaveDecisions(chatId int, decisions Decisions) error {
+	return e.WrapIfErr("couldn't save decisions", s.saveState(chatId, fileDecisions, decisions))
+}
+
+// ReadDecisions returns usable, possibly empty, decisions even if they couldn't be read
+func (s *QueriesStorage) ReadDecisions(chatId int) (Decisions, error) {
+	var decisions Decisions
+	err := s.readState(chatId, fileDecisions, &decisions)
+
+	if decisions.Vacancies == nil {
+		decisions.Vacancies = make(map[string]string)
+	}
+	if decisions.Employers == nil {
+		decisions.Employers = make(map[string]time.Time)
+	}
+	return decisions, e.WrapIfErr("couldn't read decisions", err)
+}
+
+func (s *QueriesStorage) statePath(chatId int, name string) string {
+	return filepath.Join(s.basPath, strconv.Itoa(chatId), stateDir, name)
+}
+
+func (s *QueriesStorage) saveState(chatId int, name string, v any) error {
+	return writeGob(s.statePath(chatId, name), v)
+}
+
+func (s *QueriesStorage) readState(chatId int, name string, v any) error {
+	return readGob(s.statePath(chatId, name), v)
+}
+
+// writeGob encodes v to a temporary file and renames it, so that a crash never leaves a half-written file
+func writeGob(path string, v any) (err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0774); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = gob.NewEncoder(tmp).Encode(v); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// readGob leaves v untouched if the file doesn't exist
+func readGob(path string, v any) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return gob.NewDecoder(f).Decode(v)
+}
@@ -9,6 +9,8 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Storage interface {
@@ -16,6 +18,19 @@ type Storage interface {
 	Remove(*File) error
 	ReadAll(int) ([]*File, error)
 	IsExist(*File) (bool, error)
+	SaveSeen(chatId int, seen map[string]time.Time) error
+	ReadSeen(chatId int) (map[string]time.Time, error)
+	SaveSettings(chatId int, settings Settings) error
+	ReadSettings(chatId int) (*Settings, error)
+	SavePending(chatId int, pending []Notification) error
+	ReadPending(chatId int) ([]Notification, error)
+	SaveDecisions(chatId int, decisions Decisions) error
+	ReadDecisions(chatId int) (Decisions, error)
+	ChatIDs() ([]int, error)
+	SaveOffset(Offset) error
+	ReadOffset() (*Offset, error)
+	SaveOutbox([]OutgoingMessage) error
+	ReadOutbox() ([]OutgoingMessage, error)
 }
 
 type QueriesStorage struct {
@@ -31,23 +46,14 @@ func (s *QueriesStorage) Save(f *File) (err error) {
 
 	dir := filepath.Join(s.basPath, strconv.Itoa(f.ChatID))
 
-	if err = os.MkdirAll(dir, 0774); err != nil {
-		return err
-	}
-
 	fileName, err := getFileName(f)
 	if err != nil {
 		return err
 	}
 
+	// the file is rewritten on every watermark update, so it must never be left half-written
 	path := filepath.Join(dir, fileName)
-	file, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	if err = gob.NewEncoder(file).Encode(f); err != nil {
+	if err = writeGob(path, f); err != nil {
 		return err
 	}
 
@@ -86,7 +92,8 @@ func (s *QueriesStorage) ReadAll(chatId int) (files []*File, err error) {
 
 	for _, entry := range entries {
 		var file *File
-		if !entry.IsDir() {
+		// the temporary files are left by the saves interrupted by a crash
+		if !entry.IsDir() && !strings.HasSuffix(entry.Name(), ".tmp") {
 			path := filepath.Join(dir, entry.Name())
 
 			if file, err = s.decodeFile(path); err != nil {
@@ -123,6 +130,30 @@ func (s *QueriesStorage) IsExist(f *File) (bool, error) {
 	return true, nil
 }
 
+// ChatIDs lists the chats that have anything saved
+func (s *QueriesStorage) ChatIDs() (ids []int, err error) {
+	defer func() { err = e.WrapIfErr("couldn't list chats", err) }()
+
+	entries, err := os.ReadDir(s.basPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		// the storage dir also keeps other things, e.g. hh dictionaries
+		id, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
 func (s *QueriesStorage) decodeFile(filePath string) (file *File, err error) {
 	defer func() { err = e.WrapIfErr("couldn't decode file", err) }()
 